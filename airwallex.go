@@ -3,29 +3,81 @@ package payment
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Airwallex接口地址
+const (
+	airwallexApiEndpointProd = "https://api.airwallex.com/api/v1"                           // 正式环境API端点
+	airwallexApiCheckoutProd = "https://checkout.airwallex.com/#/standalone/checkout?"      // 正式环境结账页面
+	airwallexApiEndpointDemo = "https://api-demo.airwallex.com/api/v1"                      // demo环境API端点
+	airwallexApiCheckoutDemo = "https://checkout-demo.airwallex.com/#/standalone/checkout?" // demo环境结账页面
+)
+
+// airwallexWebhookTolerance 通知时间戳与当前时间的最大差距，与 Stripe 的默认容忍时间一致
+const airwallexWebhookTolerance = 5 * time.Minute
+
+// Airwallex访问令牌缓存时间，令牌有效期为30分钟
+const (
+	airwallexTokenRefreshMargin = time.Minute      // 令牌过期前提前刷新的时间
+	airwallexTokenFallbackTTL   = 10 * time.Minute // 过期时间无法使用时的缓存时间
+)
+
 // AirwallexPaymentProvider Airwallex支付提供者结构体
 type AirwallexPaymentProvider struct {
-	Client *AirwallexClient // Airwallex客户端实例
+	Client        *AirwallexClient         // Airwallex客户端实例
+	WebhookSecret string                   // Webhook签名密钥
+	Checkout      AirwallexCheckoutOptions // 收银台配置
+	isProd        bool                     // 是否为正式环境
+}
+
+// AirwallexCheckoutOptions Airwallex收银台配置结构体
+// 托管收银台页面使用全部字段，嵌入式收银台由前端SDK自行配置
+type AirwallexCheckoutOptions struct {
+	LogoUrl string                 `json:"logoUrl,omitempty" yaml:"logoUrl,omitempty"` // Logo地址，为空时使用Airwallex默认Logo
+	Locale  string                 `json:"locale,omitempty" yaml:"locale,omitempty"`   // 语言环境，例如 "en"、"zh"，为空时由收银台自动选择，可被 PayReq.Experience.Locale 覆盖
+	Theme   map[string]interface{} `json:"theme,omitempty" yaml:"theme,omitempty"`     // 主题配置，原样传给收银台
+	Methods []string               `json:"methods,omitempty" yaml:"methods,omitempty"` // 允许的支付方式，例如 "card"、"wechatpay"、"alipaycn"，为空时不限制
 }
 
 // NewAirwallexPaymentProvider 创建新的Airwallex支付提供者实例
 // clientId: Airwallex客户端ID
 // apiKey: Airwallex API密钥
-// 返回Airwallex支付提供者实例和可能的错误
-func NewAirwallexPaymentProvider(clientId string, apiKey string) (*AirwallexPaymentProvider, error) {
-	// 设置API端点和结账页面URL
-	apiEndpoint := "https://api.airwallex.com/api/v1"
-	apiCheckout := "https://checkout.airwallex.com/#/standalone/checkout?"
+// webhookSecret: Airwallex Webhook签名密钥，用于通知验签
+// env: 网关环境，沙箱环境使用Airwallex demo环境
+// 返回Airwallex支付提供者实例和可能的错误，缺少必填参数时错误包装 ErrProviderNotConfigured
+func NewAirwallexPaymentProvider(clientId string, apiKey string, webhookSecret string, env Environment) (*AirwallexPaymentProvider, error) {
+	// 检查必要参数
+	err := checkRequiredParams(ProviderTypeAirwallex,
+		requiredParam{"clientId", clientId},
+		requiredParam{"apiKey", apiKey},
+		requiredParam{"webhookSecret", webhookSecret},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = env.Validate(); err != nil {
+		return nil, err
+	}
+	// 根据环境设置API端点和结账页面URL
+	apiEndpoint := airwallexApiEndpointProd
+	apiCheckout := airwallexApiCheckoutProd
+	if !env.IsProd() {
+		apiEndpoint = airwallexApiEndpointDemo
+		apiCheckout = airwallexApiCheckoutDemo
+	}
 	// 创建Airwallex客户端
 	client := &AirwallexClient{
 		ClientId:    clientId,
@@ -35,101 +87,331 @@ func NewAirwallexPaymentProvider(clientId string, apiKey string) (*AirwallexPaym
 		client:      &http.Client{Timeout: 15 * time.Second}, // 设置15秒超时
 	}
 	pp := &AirwallexPaymentProvider{
-		Client: client,
+		Client:        client,
+		WebhookSecret: webhookSecret,
+		isProd:        env.IsProd(),
 	}
 	return pp, nil
 }
 
 // Pay 处理Airwallex支付请求
+// 默认跳转托管收银台页面；支付环境为 PaymentEnvEmbedded 时不返回支付URL，
+// AttachInfo 包含 Drop-in/Elements 所需的 intent_id、client_secret、currency 和 env
+// ctx: 上下文
 // r: 支付请求参数
 // 返回支付响应和可能的错误
-func (pp *AirwallexPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *AirwallexPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	if err := checkRecurringNotSupported(ProviderTypeAirwallex, r); err != nil {
+		return nil, err
+	}
+
 	// 创建支付意图
-	intent, err := pp.Client.CreateIntent(r)
+	intent, err := pp.Client.CreateIntent(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	// 获取结账页面URL
-	payUrl, err := pp.Client.GetCheckoutUrl(intent, r)
+	// 嵌入式收银台由前端SDK使用支付意图完成支付
+	if r.PaymentEnv == PaymentEnvEmbedded {
+		env := "prod"
+		if !pp.isProd {
+			env = "demo"
+		}
+		return &PayResp{
+			OrderId: intent.MerchantOrderId, // 商户订单ID
+			AttachInfo: map[string]interface{}{
+				"intent_id":     intent.Id,
+				"client_secret": intent.ClientSecret,
+				"currency":      r.Price.Currency,
+				"env":           env,
+			},
+		}, nil
+	}
+
+	// 获取结账页面URL，支付请求中的语言环境优先
+	options := pp.Checkout
+	if r.Experience != nil && r.Experience.Locale != "" {
+		options.Locale = r.Experience.Locale
+	}
+	payUrl, err := pp.Client.GetCheckoutUrl(intent, r, options)
 	if err != nil {
 		return nil, err
 	}
 	return &PayResp{
-		PayUrl:  payUrl,                  // 支付URL
+		PayUrl:  payUrl,                 // 支付URL
 		OrderId: intent.MerchantOrderId, // 商户订单ID
 	}, nil
 }
 
 // Notify 处理Airwallex支付回调通知
+// 校验 x-signature 签名和 x-timestamp 时间戳，并根据事件类型返回通知结果：
+// payment_intent.* 事件使用事件中的支付意图，payment_attempt.failed 事件返回未支付状态，
+// refund.succeeded 事件查询退款的支付意图并在通知结果中附带退款结果
+// ctx: 上下文
+// header: 回调请求头，包含 x-timestamp 和 x-signature
 // body: 回调请求体
 // orderId: 订单ID
 // 返回通知结果和可能的错误
-func (pp *AirwallexPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	notifyResult := &NotifyResult{}
-	// 根据订单ID获取支付意图
-	intent, err := pp.Client.GetIntentByOrderId(orderId)
+func (pp *AirwallexPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	// 校验签名：HMAC-SHA256(x-timestamp + body)
+	mac := hmac.New(sha256.New, []byte(pp.WebhookSecret))
+	mac.Write([]byte(header.Get("x-timestamp")))
+	mac.Write(body)
+	expectedSign := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expectedSign), []byte(header.Get("x-signature"))) {
+		return nil, fmt.Errorf("%w: airwallex", ErrInvalidNotifySignature)
+	}
+	// 校验时间戳，防止截获的通知被重放
+	if err := checkAirwallexTimestamp(header.Get("x-timestamp"), time.Now()); err != nil {
+		return nil, err
+	}
+	// 解析事件
+	var event AirwallexEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(event.Name, "payment_intent."):
+		// 支付意图事件的对象为支付意图本身
+		var intent AirwallexIntent
+		if err := json.Unmarshal(event.Data.Object, &intent); err != nil {
+			return nil, err
+		}
+		// 校验订单ID
+		orderId, err := getNotifyOrderId(orderId, intent.MerchantOrderId)
+		if err != nil {
+			return nil, err
+		}
+		return getAirwallexNotifyResult(intent.toInfo(), orderId)
+	case event.Name == "payment_attempt.failed":
+		// 支付尝试失败，买家仍可使用其他支付方式重试，支付意图保持未支付
+		var attempt AirwallexPaymentAttempt
+		if err := json.Unmarshal(event.Data.Object, &attempt); err != nil {
+			return nil, err
+		}
+		orderId, err := getNotifyOrderId(orderId, attempt.MerchantOrderId)
+		if err != nil {
+			return nil, err
+		}
+		notifyResult := &NotifyResult{
+			PaymentName:   orderId,
+			PaymentStatus: PaymentStateCreated,
+			NotifyMessage: fmt.Sprintf("airwallex payment attempt %s failed: %s", attempt.Id, attempt.FailureCode),
+			OrderId:       orderId,
+		}
+		return notifyResult, nil
+	case event.Name == "refund.succeeded":
+		// 退款对象中没有商户订单号，需要查询支付意图
+		var refund AirwallexRefund
+		if err := json.Unmarshal(event.Data.Object, &refund); err != nil {
+			return nil, err
+		}
+		intent, err := pp.Client.GetIntent(ctx, refund.PaymentIntentId)
+		if err != nil {
+			return nil, err
+		}
+		orderId, err = getNotifyOrderId(orderId, intent.MerchantOrderId)
+		if err != nil {
+			return nil, err
+		}
+		notifyResult, err := getAirwallexNotifyResult(intent, orderId)
+		if err != nil {
+			return nil, err
+		}
+		price, err := ParseMoney(refund.Amount.String(), refund.Currency)
+		if err != nil {
+			return nil, err
+		}
+		notifyResult.Refund = &RefundResp{
+			RefundId:    refund.Id,
+			RefundState: RefundStateSucceeded,
+			Price:       price,
+		}
+		return notifyResult, nil
+	default:
+		return nil, fmt.Errorf("unsupported airwallex event: %s", event.Name)
+	}
+}
+
+// getAirwallexNotifyResult 根据支付意图构建通知结果
+// intent: 支付意图信息
+// orderId: 订单ID
+// 返回通知结果和可能的错误
+func getAirwallexNotifyResult(intent *AirWallexIntentInfo, orderId string) (*NotifyResult, error) {
+	notifyResult := &NotifyResult{
+		PaymentName: orderId, // 支付名称
+		OrderId:     orderId, // 订单ID
+	}
+	// 检查支付意图和支付尝试状态
+	notifyResult.PaymentStatus = getAirwallexPaymentState(intent)
+	if notifyResult.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected airwallex checkout status: %v, payment status: %v", intent.Status, intent.PaymentStatus)
+		return notifyResult, nil
+	}
+	if notifyResult.PaymentStatus != PaymentStatePaid {
+		return notifyResult, nil
+	}
+	// 支付已成功完成
+	price, err := ParseMoney(intent.Amount.String(), intent.Currency)
 	if err != nil {
 		return nil, err
 	}
+	// 构建通知结果
+	notifyResult = &NotifyResult{
+		PaymentName:   orderId,          // 支付名称
+		PaymentStatus: PaymentStatePaid, // 支付状态为已支付
+		Price:         price,            // 价格
+		OrderId:       orderId,          // 订单ID
+	}
+	// 从元数据中解析订单元数据，旧订单的产品信息保存在 description 中
+	if metadata, ok := intent.Metadata["order_metadata"].(string); ok {
+		applyOrderMetadata(notifyResult, metadata, legacyAttachNameFirst)
+	} else if description, ok := intent.Metadata["description"].(string); ok {
+		applyOrderMetadata(notifyResult, description, legacyAttachNameFirst)
+	}
+	return notifyResult, nil
+}
+
+// getAirwallexPaymentState 根据支付意图状态和最近一次支付尝试状态获取支付状态
+// intent: 支付意图信息
+// 返回支付状态，未知状态返回 PaymentStateError
+func getAirwallexPaymentState(intent *AirWallexIntentInfo) PaymentState {
 	// 检查支付意图状态
 	switch intent.Status {
 	case "PENDING", "REQUIRES_PAYMENT_METHOD", "REQUIRES_CUSTOMER_ACTION", "REQUIRES_CAPTURE":
 		// 支付进行中的各种状态
-		notifyResult.PaymentStatus = PaymentStateCreated
-		return notifyResult, nil
+		return PaymentStateCreated
 	case "CANCELLED":
 		// 支付已取消
-		notifyResult.PaymentStatus = PaymentStateCanceled
-		return notifyResult, nil
+		return PaymentStateCanceled
 	case "EXPIRED":
 		// 支付已过期
-		notifyResult.PaymentStatus = PaymentStateTimeout
-		return notifyResult, nil
+		return PaymentStateTimeout
 	case "SUCCEEDED":
-		// 支付成功，继续处理
+		// 支付成功，继续检查支付尝试状态
 	default:
 		// 未知状态，视为错误
-		notifyResult.PaymentStatus = PaymentStateError
-		notifyResult.NotifyMessage = fmt.Sprintf("unexpected airwallex checkout status: %v", intent.Status)
-		return notifyResult, nil
+		return PaymentStateError
 	}
 	// 检查支付尝试状态
+	switch intent.PaymentStatus {
+	case "CANCELLED", "EXPIRED", "RECEIVED", "AUTHENTICATION_REDIRECTED", "AUTHORIZED", "CAPTURE_REQUESTED":
+		// 支付进行中的各种状态
+		return PaymentStateCreated
+	case "", "PAID", "SETTLED":
+		// 支付已完成
+		return PaymentStatePaid
+	default:
+		// 未知支付状态，视为错误
+		return PaymentStateError
+	}
+}
+
+// QueryOrder 查询Airwallex订单
+// 根据商户订单号查询支付意图
+// ctx: 上下文
+// orderId: 订单ID
+// 返回订单查询结果和可能的错误
+func (pp *AirwallexPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	intent, err := pp.Client.GetIntentByOrderId(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	price, err := ParseMoney(intent.Amount.String(), intent.Currency)
+	if err != nil {
+		return nil, err
+	}
+	// 构建订单查询结果
+	orderResult := &OrderResult{
+		OrderId:       orderId,                          // 订单ID
+		PaymentName:   orderId,                          // 支付名称
+		PaymentStatus: getAirwallexPaymentState(intent), // 支付状态
+		VendorState:   intent.Status,                    // 支付意图状态
+		TransactionId: intent.Id,                        // 支付意图ID
+		Price:         price,                            // 价格
+		PayerId:       intent.CustomerId,                // 客户ID
+	}
 	if intent.PaymentStatus != "" {
-		switch intent.PaymentStatus {
-		case "CANCELLED", "EXPIRED", "RECEIVED", "AUTHENTICATION_REDIRECTED", "AUTHORIZED", "CAPTURE_REQUESTED":
-			// 支付进行中的各种状态
-			notifyResult.PaymentStatus = PaymentStateCreated
-			return notifyResult, nil
-		case "PAID", "SETTLED":
-			// 支付已完成，继续处理
-		default:
-			// 未知支付状态，视为错误
-			notifyResult.PaymentStatus = PaymentStateError
-			notifyResult.NotifyMessage = fmt.Sprintf("unexpected airwallex checkout payment status: %v", intent.PaymentStatus)
-			return notifyResult, nil
+		orderResult.VendorState = fmt.Sprintf("%s/%s", intent.Status, intent.PaymentStatus)
+	}
+	if intent.CapturedAmount != "" {
+		if orderResult.PaidPrice, err = ParseMoney(intent.CapturedAmount.String(), intent.Currency); err != nil {
+			return nil, err
 		}
 	}
-	// 支付已成功完成
-	var productDisplayName, productName, providerName string
-	// 从元数据中解析产品信息
-	if description, ok := intent.Metadata["description"]; ok {
-		productName, productDisplayName, providerName, _ = parseAttachString(description.(string))
+	if orderResult.CreateTime, err = parseAirwallexTime(intent.CreatedAt); err != nil {
+		return nil, err
 	}
-	orderId = intent.MerchantOrderId
-	// 构建通知结果
-	return &NotifyResult{
-		PaymentName:        orderId,                                     // 支付名称
-		PaymentStatus:      PaymentStatePaid,                           // 支付状态为已支付
-		ProductName:        productName,                                 // 产品名称
-		ProductDisplayName: productDisplayName,                         // 产品显示名称
-		ProviderName:       providerName,                               // 提供者名称
-		Price:              priceStringToFloat64(intent.Amount.String()), // 价格
-		Currency:           intent.Currency,                            // 货币
-		OrderId:            orderId,                                     // 订单ID
-	}, nil
+	// 支付成功后支付意图不再变化，更新时间即支付完成时间
+	if orderResult.PaymentStatus == PaymentStatePaid {
+		if orderResult.PayTime, err = parseAirwallexTime(intent.UpdatedAt); err != nil {
+			return nil, err
+		}
+	}
+	// 从元数据中解析订单元数据，旧订单的产品信息保存在 description 中
+	if metadata, ok := intent.Metadata["order_metadata"].(string); ok {
+		applyOrderMetadataToOrder(orderResult, metadata, legacyAttachNameFirst)
+	} else if description, ok := intent.Metadata["description"].(string); ok {
+		applyOrderMetadataToOrder(orderResult, description, legacyAttachNameFirst)
+	}
+	return orderResult, nil
+}
+
+// CloseOrder 关闭Airwallex订单
+// 取消订单对应的支付意图，已成功的支付意图不能取消
+// ctx: 上下文
+// orderId: 订单ID
+// 返回可能的错误
+func (pp *AirwallexPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 检查订单状态
+	intent, err := pp.Client.GetIntentByOrderId(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	orderResult := &OrderResult{
+		OrderId:       orderId,
+		PaymentStatus: getAirwallexPaymentState(intent),
+		VendorState:   intent.Status,
+	}
+	if ok, err := checkOrderClosable(ProviderTypeAirwallex, orderResult); !ok {
+		return err
+	}
+	// 取消支付意图
+	return pp.Client.CancelIntent(ctx, intent.Id)
+}
+
+// checkAirwallexTimestamp 校验通知时间戳与当前时间的差距不超过 airwallexWebhookTolerance
+// value: x-timestamp 请求头，毫秒级Unix时间戳
+// now: 当前时间
+// 返回可能的错误，时间戳无效或超出容忍时间时错误包装 ErrInvalidNotifySignature
+func checkAirwallexTimestamp(value string, now time.Time) error {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: airwallex: invalid timestamp %q", ErrInvalidNotifySignature, value)
+	}
+	diff := now.Sub(time.UnixMilli(ms))
+	if diff > airwallexWebhookTolerance || diff < -airwallexWebhookTolerance {
+		return fmt.Errorf("%w: airwallex: timestamp %s is outside the tolerance of %v", ErrInvalidNotifySignature, value, airwallexWebhookTolerance)
+	}
+	return nil
+}
+
+// parseAirwallexTime 解析Airwallex接口返回的时间
+// Airwallex的时区偏移不带冒号，例如 2021-01-02T03:04:05+0000
+// value: 时间字符串，为空时返回零值
+// 返回解析后的时间和可能的错误
+func parseAirwallexTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02T15:04:05-0700", value)
+	if err != nil {
+		return time.Parse(time.RFC3339, value)
+	}
+	return t, nil
 }
 
 // GetInvoice 获取发票信息
+// ctx: 上下文
 // paymentName: 支付名称
 // personName: 个人姓名
 // personIdCard: 身份证号
@@ -139,11 +421,51 @@ func (pp *AirwallexPaymentProvider) Notify(body []byte, orderId string) (*Notify
 // invoiceTitle: 发票抬头
 // invoiceTaxId: 税号
 // 返回发票URL和可能的错误
-func (pp *AirwallexPaymentProvider) GetInvoice(paymentName, personName, personIdCard, personEmail, personPhone, invoiceType, invoiceTitle, invoiceTaxId string) (string, error) {
+func (pp *AirwallexPaymentProvider) GetInvoice(ctx context.Context, paymentName, personName, personIdCard, personEmail, personPhone, invoiceType, invoiceTitle, invoiceTaxId string) (string, error) {
 	// Airwallex暂不支持发票功能
 	return "", nil
 }
 
+// Refund 处理Airwallex退款请求
+// 根据订单ID找到支付意图并对其发起退款
+// ctx: 上下文
+// r: 退款请求参数
+// 返回退款响应和可能的错误
+func (pp *AirwallexPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	// 根据订单ID获取支付意图
+	intent, err := pp.Client.GetIntentByOrderId(ctx, r.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	// 发起退款
+	refund, err := pp.Client.CreateRefund(ctx, intent.Id, r)
+	if err != nil {
+		return nil, err
+	}
+	// 根据退款状态设置结果
+	var refundState RefundState
+	switch refund.Status {
+	case "SUCCEEDED":
+		// 退款成功
+		refundState = RefundStateSucceeded
+	case "RECEIVED", "ACCEPTED", "SETTLED":
+		// 退款处理中
+		refundState = RefundStatePending
+	default:
+		// 退款失败
+		refundState = RefundStateFailed
+	}
+	price, err := ParseMoney(refund.Amount.String(), refund.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &RefundResp{
+		RefundId:    refund.Id,   // 退款ID
+		RefundState: refundState, // 退款状态
+		Price:       price,       // 退款金额
+	}, nil
+}
+
 // GetResponseError 获取响应错误信息
 // err: 错误对象
 // 返回错误描述字符串
@@ -183,81 +505,190 @@ type AirWallexIntentResp struct {
 	MerchantOrderId string `json:"merchant_order_id"` // 商户订单ID
 }
 
-func (c *AirwallexClient) GetToken() (string, error) {
+func (c *AirwallexClient) GetToken(ctx context.Context) (string, error) {
 	c.tokenMutex.Lock()
 	defer c.tokenMutex.Unlock()
 	if c.tokenCache != nil && time.Now().Before(c.tokenCache.parsedExpiresAt) {
 		return c.tokenCache.Token, nil
 	}
-	req, _ := http.NewRequest("POST", c.APIEndpoint+"/authentication/login", bytes.NewBuffer([]byte("{}")))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.APIEndpoint+"/authentication/login", bytes.NewBuffer([]byte("{}")))
+	if err != nil {
+		return "", err
+	}
 	req.Header.Set("x-client-id", c.ClientId)
 	req.Header.Set("x-api-key", c.APIKey)
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", err
+		return "", wrapError(ProviderTypeAirwallex, err)
 	}
 	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", wrapError(ProviderTypeAirwallex, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", wrapAirwallexError(resp.StatusCode, respBytes)
+	}
 	var result AirWallexTokenInfo
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	if err := json.Unmarshal(respBytes, &result); err != nil {
+		return "", wrapError(ProviderTypeAirwallex, fmt.Errorf("invalid airwallex token response: %w", err))
 	}
 	if result.Token == "" {
-		return "", fmt.Errorf("invalid token response")
+		return "", newError(ProviderTypeAirwallex, ErrorCodeAuthFailed, "", "token response has no token")
 	}
-	expiresAt := strings.Replace(result.ExpiresAt, "+0000", "+00:00", 1)
-	result.parsedExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	result.parsedExpiresAt = getAirwallexTokenExpiry(result.ExpiresAt, time.Now())
 	c.tokenCache = &result
 	return result.Token, nil
 }
 
-func (c *AirwallexClient) authRequest(method, url string, body interface{}) (map[string]interface{}, error) {
-	token, err := c.GetToken()
+// getAirwallexTokenExpiry 计算访问令牌的缓存过期时间
+// 提前 airwallexTokenRefreshMargin 刷新令牌；过期时间无法解析或已过期（例如本地时钟偏差）时缓存 airwallexTokenFallbackTTL
+// expiresAt: 令牌响应中的过期时间
+// now: 当前时间
+// 返回令牌缓存的过期时间
+func getAirwallexTokenExpiry(expiresAt string, now time.Time) time.Time {
+	t, err := parseAirwallexTime(expiresAt)
+	if err != nil || t.IsZero() {
+		return now.Add(airwallexTokenFallbackTTL)
+	}
+	expiry := t.Add(-airwallexTokenRefreshMargin)
+	if !expiry.After(now) {
+		return now.Add(airwallexTokenFallbackTTL)
+	}
+	return expiry
+}
+
+// authRequest 使用访问令牌调用Airwallex接口
+// ctx: 上下文
+// method: HTTP方法
+// url: 接口地址
+// body: 请求体，GET请求时忽略
+// result: 响应体解析目标，为nil时不解析
+// 返回可能的错误，非2xx响应时返回包含Airwallex错误码和错误信息的支付错误
+func (c *AirwallexClient) authRequest(ctx context.Context, method, url string, body interface{}, result interface{}) error {
+	token, err := c.GetToken(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	b, _ := json.Marshal(body)
 	var reqBody io.Reader
 	if method != "GET" {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
 		reqBody = bytes.NewBuffer(b)
 	}
-	req, _ := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return wrapError(ProviderTypeAirwallex, err)
 	}
 	defer resp.Body.Close()
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return wrapError(ProviderTypeAirwallex, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return wrapAirwallexError(resp.StatusCode, respBytes)
 	}
-	return result, nil
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBytes, result); err != nil {
+		return wrapError(ProviderTypeAirwallex, fmt.Errorf("invalid airwallex response: %w", err))
+	}
+	return nil
+}
+
+// airwallexIntentReq Airwallex创建支付意图请求结构体
+type airwallexIntentReq struct {
+	RequestId       string             `json:"request_id"`           // 请求ID，用于幂等控制
+	Amount          json.Number        `json:"amount"`               // 金额
+	Currency        string             `json:"currency"`             // 货币
+	MerchantOrderId string             `json:"merchant_order_id"`    // 商户订单ID
+	Descriptor      string             `json:"descriptor,omitempty"` // 账单描述，最长32个字符
+	Metadata        map[string]string  `json:"metadata,omitempty"`   // 元数据
+	Order           *airwallexOrder    `json:"order,omitempty"`      // 订单信息
+	Customer        *airwallexCustomer `json:"customer,omitempty"`   // 客户信息
+}
+
+// airwallexOrder Airwallex订单信息结构体
+type airwallexOrder struct {
+	Products []airwallexProduct `json:"products"` // 产品列表
+}
+
+// airwallexProduct Airwallex产品信息结构体
+type airwallexProduct struct {
+	Name     string `json:"name,omitempty"`      // 产品名称
+	Quantity int    `json:"quantity"`            // 数量
+	Desc     string `json:"desc,omitempty"`      // 产品描述
+	ImageUrl string `json:"image_url,omitempty"` // 产品图片
+}
+
+// airwallexCustomer Airwallex客户信息结构体
+type airwallexCustomer struct {
+	MerchantCustomerId string `json:"merchant_customer_id,omitempty"` // 商户客户ID
+	Email              string `json:"email,omitempty"`                // 邮箱
+	FirstName          string `json:"first_name,omitempty"`           // 名
+	LastName           string `json:"last_name,omitempty"`            // 姓
 }
 
-func (c *AirwallexClient) CreateIntent(r *PayReq) (*AirWallexIntentResp, error) {
-	description := joinAttachString([]string{r.ProductName, r.ProductDisplayName, r.ProviderName})
+// CreateIntent 创建支付意图
+// ctx: 上下文
+// r: 支付请求参数，以支付名称作为商户订单ID和请求ID
+// 返回支付意图响应和可能的错误
+func (c *AirwallexClient) CreateIntent(ctx context.Context, r *PayReq) (*AirWallexIntentResp, error) {
+	metadata, err := encodeOrderMetadata(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	// 账单描述最长32个字符
+	descriptor := []rune(r.ProductDisplayName)
+	if len(descriptor) > 32 {
+		descriptor = descriptor[:32]
+	}
 	orderId := r.PaymentName
-	intentReq := map[string]interface{}{
-		"currency":          r.Currency,
-		"amount":            r.Price,
-		"merchant_order_id": orderId,
-		"request_id":        orderId,
-		"descriptor":        strings.ReplaceAll(string([]rune(description)[:32]), "\x00", ""),
-		"metadata":          map[string]interface{}{"description": description},
-		"order":             map[string]interface{}{"products": []map[string]interface{}{{"name": r.ProductDisplayName, "quantity": 1, "desc": r.ProductDescription, "image_url": r.ProductImage}}},
-		"customer":          map[string]interface{}{"merchant_customer_id": r.PayerId, "email": r.PayerEmail, "first_name": r.PayerName, "last_name": r.PayerName},
+	intentReq := &airwallexIntentReq{
+		RequestId:       orderId,
+		Amount:          json.Number(r.Price.String()),
+		Currency:        r.Price.Currency,
+		MerchantOrderId: orderId,
+		Descriptor:      strings.ReplaceAll(string(descriptor), "\x00", ""),
+		Metadata:        map[string]string{"order_metadata": metadata},
+		Order: &airwallexOrder{
+			Products: []airwallexProduct{
+				{
+					Name:     r.ProductDisplayName,
+					Quantity: 1,
+					Desc:     r.ProductDescription,
+					ImageUrl: r.ProductImage,
+				},
+			},
+		},
+		Customer: &airwallexCustomer{
+			MerchantCustomerId: r.PayerId,
+			Email:              r.PayerEmail,
+			FirstName:          r.PayerName,
+			LastName:           r.PayerName,
+		},
 	}
 	intentUrl := fmt.Sprintf("%s/pa/payment_intents/create", c.APIEndpoint)
-	intentRes, err := c.authRequest("POST", intentUrl, intentReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create payment intent: %v", err)
+	var intent AirWallexIntentResp
+	if err = c.authRequest(ctx, "POST", intentUrl, intentReq, &intent); err != nil {
+		return nil, fmt.Errorf("failed to create payment intent: %w", err)
 	}
-	return &AirWallexIntentResp{
-		Id:              intentRes["id"].(string),
-		ClientSecret:    intentRes["client_secret"].(string),
-		MerchantOrderId: intentRes["merchant_order_id"].(string),
-	}, nil
+	if intent.Id == "" || intent.ClientSecret == "" {
+		return nil, newError(ProviderTypeAirwallex, ErrorCodeProviderUnavailable, "", "payment intent response has no id or client secret")
+	}
+	if intent.MerchantOrderId == "" {
+		intent.MerchantOrderId = orderId
+	}
+	return &intent, nil
 }
 
 type AirwallexIntent struct {
@@ -267,12 +698,54 @@ type AirwallexIntent struct {
 	Status               string      `json:"status"`
 	Descriptor           string      `json:"descriptor"`
 	MerchantOrderId      string      `json:"merchant_order_id"`
+	CapturedAmount       json.Number `json:"captured_amount"`
+	CustomerId           string      `json:"customer_id"`
+	CreatedAt            string      `json:"created_at"`
+	UpdatedAt            string      `json:"updated_at"`
 	LatestPaymentAttempt struct {
 		Status string `json:"status"`
 	} `json:"latest_payment_attempt"`
 	Metadata map[string]interface{} `json:"metadata"`
 }
 
+// toInfo 转换为支付意图信息
+func (intent *AirwallexIntent) toInfo() *AirWallexIntentInfo {
+	return &AirWallexIntentInfo{
+		Id:              intent.Id,
+		Amount:          intent.Amount,
+		Currency:        intent.Currency,
+		Status:          intent.Status,
+		Descriptor:      intent.Descriptor,
+		MerchantOrderId: intent.MerchantOrderId,
+		CapturedAmount:  intent.CapturedAmount,
+		CustomerId:      intent.CustomerId,
+		CreatedAt:       intent.CreatedAt,
+		UpdatedAt:       intent.UpdatedAt,
+		PaymentStatus:   intent.LatestPaymentAttempt.Status,
+		Metadata:        intent.Metadata,
+	}
+}
+
+// AirwallexEvent Airwallex Webhook事件结构体
+type AirwallexEvent struct {
+	Id   string `json:"id"`   // 事件ID
+	Name string `json:"name"` // 事件名称，例如 payment_intent.succeeded、payment_attempt.failed、refund.succeeded
+	Data struct {
+		Object json.RawMessage `json:"object"` // 事件关联的对象，支付意图、支付尝试或退款
+	} `json:"data"`
+}
+
+// AirwallexPaymentAttempt Airwallex支付尝试结构体
+type AirwallexPaymentAttempt struct {
+	Id              string      `json:"id"`                // 支付尝试ID
+	PaymentIntentId string      `json:"payment_intent_id"` // 支付意图ID
+	MerchantOrderId string      `json:"merchant_order_id"` // 商户订单ID
+	Amount          json.Number `json:"amount"`            // 金额
+	Currency        string      `json:"currency"`          // 货币
+	Status          string      `json:"status"`            // 支付尝试状态
+	FailureCode     string      `json:"failure_code"`      // 失败原因
+}
+
 type AirwallexIntents struct {
 	Items []AirwallexIntent `json:"items"`
 }
@@ -284,46 +757,193 @@ type AirWallexIntentInfo struct {
 	Status          string
 	Descriptor      string
 	MerchantOrderId string
+	CapturedAmount  json.Number
+	CustomerId      string
+	CreatedAt       string
+	UpdatedAt       string
 	PaymentStatus   string
 	Metadata        map[string]interface{}
 }
 
-func (c *AirwallexClient) GetIntentByOrderId(orderId string) (*AirWallexIntentInfo, error) {
-	intentUrl := fmt.Sprintf("%s/pa/payment_intents/?merchant_order_id=%s", c.APIEndpoint, orderId)
-	intentRes, err := c.authRequest("GET", intentUrl, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get payment intent: %v", err)
+// GetIntentByOrderId 根据商户订单ID查询支付意图
+// ctx: 上下文
+// orderId: 商户订单ID
+// 返回支付意图信息和可能的错误，未找到时返回错误码为 ErrorCodeNotFound 的支付错误
+func (c *AirwallexClient) GetIntentByOrderId(ctx context.Context, orderId string) (*AirWallexIntentInfo, error) {
+	query := url.Values{}
+	query.Set("merchant_order_id", orderId)
+	intentUrl := fmt.Sprintf("%s/pa/payment_intents/?%s", c.APIEndpoint, query.Encode())
+	var intents AirwallexIntents
+	if err := c.authRequest(ctx, "GET", intentUrl, nil, &intents); err != nil {
+		return nil, fmt.Errorf("failed to get payment intent: %w", err)
 	}
-	items := intentRes["items"].([]interface{})
-	if len(items) == 0 {
-		return nil, fmt.Errorf("no payment intent found for order id: %s", orderId)
+	if len(intents.Items) == 0 {
+		return nil, newError(ProviderTypeAirwallex, ErrorCodeNotFound, "", fmt.Sprintf("no payment intent found for order id: %s", orderId))
 	}
+	return intents.Items[0].toInfo(), nil
+}
+
+// GetIntent 根据支付意图ID查询支付意图
+// ctx: 上下文
+// intentId: 支付意图ID
+// 返回支付意图信息和可能的错误
+func (c *AirwallexClient) GetIntent(ctx context.Context, intentId string) (*AirWallexIntentInfo, error) {
+	intentUrl := fmt.Sprintf("%s/pa/payment_intents/%s", c.APIEndpoint, url.PathEscape(intentId))
 	var intent AirwallexIntent
-	if b, err := json.Marshal(items[0]); err == nil {
-		json.Unmarshal(b, &intent)
+	if err := c.authRequest(ctx, "GET", intentUrl, nil, &intent); err != nil {
+		return nil, fmt.Errorf("failed to get payment intent: %w", err)
 	}
-	return &AirWallexIntentInfo{
-		Id:              intent.Id,
-		Amount:          intent.Amount,
-		Currency:        intent.Currency,
-		Status:          intent.Status,
-		Descriptor:      intent.Descriptor,
-		MerchantOrderId: intent.MerchantOrderId,
-		PaymentStatus:   intent.LatestPaymentAttempt.Status,
-		Metadata:        intent.Metadata,
-	}, nil
+	if intent.Id == "" {
+		return nil, newError(ProviderTypeAirwallex, ErrorCodeProviderUnavailable, "", "payment intent response has no id")
+	}
+	return intent.toInfo(), nil
 }
 
-func (c *AirwallexClient) GetCheckoutUrl(intent *AirWallexIntentResp, r *PayReq) (string, error) {
-	return fmt.Sprintf("%sintent_id=%s&client_secret=%s&mode=payment&currency=%s&amount=%v&requiredBillingContactFields=%s&successUrl=%s&failUrl=%s&logoUrl=%s",
-		c.APICheckout,
-		intent.Id,
-		intent.ClientSecret,
-		r.Currency,
-		r.Price,
-		url.QueryEscape(`["address"]`),
-		r.ReturnUrl,
-		r.ReturnUrl,
-		"data:image/gif;base64,R0lGODlhAQABAAD/ACwAAAAAAQABAAACADs=", // replace default logo
-	), nil
+// AirwallexRefund Airwallex退款结构体
+type AirwallexRefund struct {
+	Id              string      `json:"id"`
+	PaymentIntentId string      `json:"payment_intent_id"`
+	Amount          json.Number `json:"amount"`
+	Currency        string      `json:"currency"`
+	Reason          string      `json:"reason"`
+	Status          string      `json:"status"`
+}
+
+// airwallexRefundReq Airwallex创建退款请求结构体
+type airwallexRefundReq struct {
+	PaymentIntentId string      `json:"payment_intent_id"` // 支付意图ID
+	RequestId       string      `json:"request_id"`        // 请求ID，用于幂等控制
+	Amount          json.Number `json:"amount,omitempty"`  // 退款金额，为空时全额退款
+	Reason          string      `json:"reason,omitempty"`  // 退款原因
+}
+
+// CreateRefund 对支付意图发起退款
+// ctx: 上下文
+// intentId: 支付意图ID
+// r: 退款请求参数
+// 返回退款信息和可能的错误
+func (c *AirwallexClient) CreateRefund(ctx context.Context, intentId string, r RefundReq) (*AirwallexRefund, error) {
+	requestId, err := getRefundRequestNo(ProviderTypeAirwallex, r)
+	if err != nil {
+		return nil, err
+	}
+	refundReq := &airwallexRefundReq{
+		PaymentIntentId: intentId,
+		RequestId:       requestId,
+		Reason:          r.Reason,
+	}
+	// 未指定金额时Airwallex默认全额退款
+	if !r.Price.IsZero() {
+		refundReq.Amount = json.Number(r.Price.String())
+	}
+	refundUrl := fmt.Sprintf("%s/pa/refunds/create", c.APIEndpoint)
+	var refund AirwallexRefund
+	if err := c.authRequest(ctx, "POST", refundUrl, refundReq, &refund); err != nil {
+		return nil, fmt.Errorf("failed to create refund: %w", err)
+	}
+	if refund.Id == "" {
+		return nil, newError(ProviderTypeAirwallex, ErrorCodeProviderUnavailable, "", "refund response has no id")
+	}
+	return &refund, nil
+}
+
+// airwallexCancelReq Airwallex取消支付意图请求结构体
+type airwallexCancelReq struct {
+	RequestId          string `json:"request_id"`          // 请求ID
+	CancellationReason string `json:"cancellation_reason"` // 取消原因
+}
+
+// CancelIntent 取消支付意图
+// ctx: 上下文
+// intentId: 支付意图ID
+// 返回可能的错误
+func (c *AirwallexClient) CancelIntent(ctx context.Context, intentId string) error {
+	cancelReq := &airwallexCancelReq{
+		RequestId:          GetRandomString(32),
+		CancellationReason: "Order closed by merchant",
+	}
+	cancelUrl := fmt.Sprintf("%s/pa/payment_intents/%s/cancel", c.APIEndpoint, url.PathEscape(intentId))
+	if err := c.authRequest(ctx, "POST", cancelUrl, cancelReq, nil); err != nil {
+		return fmt.Errorf("failed to cancel payment intent: %w", err)
+	}
+	return nil
+}
+
+// GetCheckoutUrl 获取托管收银台页面URL
+// 所有参数都经过URL编码，数组和对象参数编码为JSON
+// intent: 支付意图
+// r: 支付请求参数，成功返回 ReturnUrl，失败返回 FailUrl
+// options: 收银台配置
+// 返回收银台页面URL和可能的错误
+func (c *AirwallexClient) GetCheckoutUrl(intent *AirWallexIntentResp, r *PayReq, options AirwallexCheckoutOptions) (string, error) {
+	query := url.Values{}
+	query.Set("intent_id", intent.Id)
+	query.Set("client_secret", intent.ClientSecret)
+	query.Set("mode", "payment")
+	query.Set("currency", r.Price.Currency)
+	query.Set("amount", r.Price.String())
+	query.Set("requiredBillingContactFields", `["address"]`)
+	query.Set("successUrl", r.ReturnUrl)
+	query.Set("failUrl", r.getFailUrl())
+	if options.LogoUrl != "" {
+		query.Set("logoUrl", options.LogoUrl)
+	}
+	if options.Locale != "" {
+		query.Set("locale", options.Locale)
+	}
+	if len(options.Theme) > 0 {
+		theme, err := json.Marshal(options.Theme)
+		if err != nil {
+			return "", err
+		}
+		query.Set("theme", string(theme))
+	}
+	if len(options.Methods) > 0 {
+		methods, err := json.Marshal(options.Methods)
+		if err != nil {
+			return "", err
+		}
+		query.Set("methods", string(methods))
+	}
+	return c.APICheckout + query.Encode(), nil
+}
+
+// airwallexErrorCodes Airwallex错误码与支付错误码的映射
+var airwallexErrorCodes = map[string]ErrorCode{
+	"validation_error":             ErrorCodeInvalidRequest,
+	"invalid_argument":             ErrorCodeInvalidRequest,
+	"credentials_invalid":          ErrorCodeAuthFailed,
+	"credentials_expired":          ErrorCodeAuthFailed,
+	"unauthorized":                 ErrorCodeAuthFailed,
+	"resource_not_found":           ErrorCodeNotFound,
+	"not_found":                    ErrorCodeNotFound,
+	"duplicate_request":            ErrorCodeDuplicateOrder,
+	"already_exists":               ErrorCodeDuplicateOrder,
+	"invalid_status_for_operation": ErrorCodeOrderClosed,
+	"insufficient_fund":            ErrorCodeInsufficientFunds,
+	"payment_declined":             ErrorCodeCardDeclined,
+	"issuer_declined":              ErrorCodeCardDeclined,
+	"too_many_requests":            ErrorCodeRateLimited,
+	"frequency_above_limit":        ErrorCodeRateLimited,
+	"internal_error":               ErrorCodeProviderUnavailable,
+	"service_unavailable":          ErrorCodeProviderUnavailable,
+}
+
+// wrapAirwallexError 将Airwallex错误响应映射为支付错误
+// status: HTTP状态码
+// body: 错误响应体，格式为 {"code":"...","message":"..."}
+// 返回支付错误
+func wrapAirwallexError(status int, body []byte) error {
+	var errRsp struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &errRsp) != nil {
+		errRsp.Message = string(body)
+	}
+	code, ok := airwallexErrorCodes[errRsp.Code]
+	if !ok {
+		code = getErrorCodeByHTTPStatus(status)
+	}
+	return newError(ProviderTypeAirwallex, code, errRsp.Code, errRsp.Message)
 }
@@ -0,0 +1,452 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// airwallexTestToken 替身服务登录接口返回的访问令牌
+const airwallexTestToken = "test_token"
+
+// newTestAirwallexProvider 创建指向本地替身服务的Airwallex提供商
+// 替身服务的登录接口返回有效的访问令牌，其他接口由 handler 处理
+func newTestAirwallexProvider(t *testing.T, handler http.HandlerFunc) *AirwallexPaymentProvider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authentication/login", func(w http.ResponseWriter, r *http.Request) {
+		expiresAt := time.Now().Add(30 * time.Minute).UTC().Format("2006-01-02T15:04:05-0700")
+		_, _ = io.WriteString(w, `{"token":"`+airwallexTestToken+`","expires_at":"`+expiresAt+`"}`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer "+airwallexTestToken {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer "+airwallexTestToken)
+		}
+		handler(w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	pp, err := NewAirwallexPaymentProvider("client_id", "api_key", "webhook_secret", EnvironmentSandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp.Client.APIEndpoint = server.URL + "/api/v1"
+	pp.Client.APICheckout = server.URL + "/checkout?"
+	return pp
+}
+
+// airwallexStub 返回以固定状态码和响应体应答的处理函数
+func airwallexStub(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+// newTestAirwallexPayReq 创建测试用的支付请求
+func newTestAirwallexPayReq(productDisplayName string) *PayReq {
+	return &PayReq{
+		ProductName:        "product",
+		ProductDisplayName: productDisplayName,
+		PaymentName:        "payment_1",
+		Price:              NewMoney(1000, "USD"),
+		ReturnUrl:          "https://example.com/return",
+	}
+}
+
+func TestAirwallexCreateIntent(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantId   string
+		wantCode ErrorCode
+	}{
+		{
+			name:   "created",
+			status: http.StatusCreated,
+			body:   `{"id":"int_1","client_secret":"secret_1","merchant_order_id":"payment_1"}`,
+			wantId: "int_1",
+		},
+		{
+			name:     "missing id",
+			status:   http.StatusCreated,
+			body:     `{"client_secret":"secret_1","merchant_order_id":"payment_1"}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "missing client secret",
+			status:   http.StatusCreated,
+			body:     `{"id":"int_1","merchant_order_id":"payment_1"}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "validation error",
+			status:   http.StatusBadRequest,
+			body:     `{"code":"validation_error","message":"amount must be greater than 0"}`,
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name:     "duplicate request",
+			status:   http.StatusBadRequest,
+			body:     `{"code":"duplicate_request","message":"request_id already used"}`,
+			wantCode: ErrorCodeDuplicateOrder,
+		},
+		{
+			name:     "unknown error code falls back to status",
+			status:   http.StatusTooManyRequests,
+			body:     `{"code":"something_new","message":"slow down"}`,
+			wantCode: ErrorCodeRateLimited,
+		},
+		{
+			name:     "non-json server error",
+			status:   http.StatusBadGateway,
+			body:     `<html>Bad Gateway</html>`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "malformed response",
+			status:   http.StatusCreated,
+			body:     `{"id":1}`,
+			wantCode: ErrorCodeUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newTestAirwallexProvider(t, airwallexStub(tt.status, tt.body))
+			intent, err := pp.Client.CreateIntent(context.Background(), newTestAirwallexPayReq("Product"))
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if intent.Id != tt.wantId {
+				t.Errorf("Id = %q, want %q", intent.Id, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestAirwallexCreateIntentDescriptor(t *testing.T) {
+	tests := []struct {
+		name               string
+		productDisplayName string
+		wantDescriptor     string
+	}{
+		{
+			name:               "empty",
+			productDisplayName: "",
+			wantDescriptor:     "",
+		},
+		{
+			name:               "shorter than 32 runes",
+			productDisplayName: "Pro",
+			wantDescriptor:     "Pro",
+		},
+		{
+			name:               "multi-byte shorter than 32 runes",
+			productDisplayName: "专业版会员",
+			wantDescriptor:     "专业版会员",
+		},
+		{
+			name:               "exactly 32 runes",
+			productDisplayName: strings.Repeat("a", 32),
+			wantDescriptor:     strings.Repeat("a", 32),
+		},
+		{
+			name:               "longer than 32 runes",
+			productDisplayName: strings.Repeat("会", 40),
+			wantDescriptor:     strings.Repeat("会", 32),
+		},
+		{
+			name:               "null characters removed",
+			productDisplayName: "Pro\x00",
+			wantDescriptor:     "Pro",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var intentReq airwallexIntentReq
+			pp := newTestAirwallexProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&intentReq); err != nil {
+					t.Errorf("invalid request body: %v", err)
+				}
+				_, _ = io.WriteString(w, `{"id":"int_1","client_secret":"secret_1"}`)
+			})
+			if _, err := pp.Client.CreateIntent(context.Background(), newTestAirwallexPayReq(tt.productDisplayName)); err != nil {
+				t.Fatal(err)
+			}
+			if intentReq.Descriptor != tt.wantDescriptor {
+				t.Errorf("Descriptor = %q, want %q", intentReq.Descriptor, tt.wantDescriptor)
+			}
+		})
+	}
+}
+
+func TestAirwallexGetIntentByOrderId(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantId   string
+		wantCode ErrorCode
+	}{
+		{
+			name:   "found",
+			status: http.StatusOK,
+			body:   `{"items":[{"id":"int_1","status":"SUCCEEDED","merchant_order_id":"payment_1","amount":10,"currency":"USD"}]}`,
+			wantId: "int_1",
+		},
+		{
+			name:     "empty items",
+			status:   http.StatusOK,
+			body:     `{"items":[]}`,
+			wantCode: ErrorCodeNotFound,
+		},
+		{
+			name:     "missing items",
+			status:   http.StatusOK,
+			body:     `{}`,
+			wantCode: ErrorCodeNotFound,
+		},
+		{
+			name:     "unauthorized",
+			status:   http.StatusUnauthorized,
+			body:     `{"code":"unauthorized","message":"Access denied"}`,
+			wantCode: ErrorCodeAuthFailed,
+		},
+		{
+			name:     "empty server error",
+			status:   http.StatusInternalServerError,
+			body:     ``,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newTestAirwallexProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("merchant_order_id"); got != "payment_1" {
+					t.Errorf("merchant_order_id = %q, want %q", got, "payment_1")
+				}
+				airwallexStub(tt.status, tt.body)(w, r)
+			})
+			intent, err := pp.Client.GetIntentByOrderId(context.Background(), "payment_1")
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if intent.Id != tt.wantId {
+				t.Errorf("Id = %q, want %q", intent.Id, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestGetAirwallexTokenExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		expiresAt string
+		want      time.Time
+	}{
+		{
+			name:      "utc offset without colon",
+			expiresAt: "2024-01-02T03:34:05+0000",
+			want:      now.Add(29 * time.Minute),
+		},
+		{
+			name:      "non-utc offset without colon",
+			expiresAt: "2024-01-02T11:34:05+0800",
+			want:      now.Add(29 * time.Minute),
+		},
+		{
+			name:      "rfc3339",
+			expiresAt: "2024-01-02T03:34:05Z",
+			want:      now.Add(29 * time.Minute),
+		},
+		{
+			name:      "expired",
+			expiresAt: "2024-01-02T02:34:05+0000",
+			want:      now.Add(airwallexTokenFallbackTTL),
+		},
+		{
+			name:      "expires within refresh margin",
+			expiresAt: "2024-01-02T03:04:35+0000",
+			want:      now.Add(airwallexTokenFallbackTTL),
+		},
+		{
+			name:      "unparsable",
+			expiresAt: "tomorrow",
+			want:      now.Add(airwallexTokenFallbackTTL),
+		},
+		{
+			name:      "empty",
+			expiresAt: "",
+			want:      now.Add(airwallexTokenFallbackTTL),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getAirwallexTokenExpiry(tt.expiresAt, now); !got.Equal(tt.want) {
+				t.Errorf("getAirwallexTokenExpiry(%q) = %v, want %v", tt.expiresAt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAirwallexGetToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantLogins int32
+		wantCode   ErrorCode
+	}{
+		{
+			name:       "expired token is cached",
+			status:     http.StatusCreated,
+			body:       `{"token":"token_1","expires_at":"2000-01-01T00:00:00+0000"}`,
+			wantLogins: 1,
+		},
+		{
+			name:       "unparsable expires_at is cached",
+			status:     http.StatusCreated,
+			body:       `{"token":"token_1","expires_at":"soon"}`,
+			wantLogins: 1,
+		},
+		{
+			name:       "missing token",
+			status:     http.StatusCreated,
+			body:       `{"expires_at":"2000-01-01T00:00:00+0000"}`,
+			wantLogins: 2,
+			wantCode:   ErrorCodeAuthFailed,
+		},
+		{
+			name:       "invalid credentials",
+			status:     http.StatusUnauthorized,
+			body:       `{"code":"credentials_invalid","message":"Invalid API key"}`,
+			wantLogins: 2,
+			wantCode:   ErrorCodeAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logins int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&logins, 1)
+				if r.Header.Get("x-client-id") != "client_id" || r.Header.Get("x-api-key") != "api_key" {
+					t.Errorf("missing credentials headers")
+				}
+				airwallexStub(tt.status, tt.body)(w, r)
+			}))
+			defer server.Close()
+
+			pp, err := NewAirwallexPaymentProvider("client_id", "api_key", "webhook_secret", EnvironmentSandbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pp.Client.APIEndpoint = server.URL
+			for i := 0; i < 2; i++ {
+				token, err := pp.Client.GetToken(context.Background())
+				checkPaymentError(t, err, tt.wantCode)
+				if tt.wantCode == "" && token != "token_1" {
+					t.Errorf("token = %q, want %q", token, "token_1")
+				}
+			}
+			if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+				t.Errorf("login requests = %d, want %d", got, tt.wantLogins)
+			}
+		})
+	}
+}
+
+// signAirwallexNotify 构造带签名的Airwallex通知请求头
+func signAirwallexNotify(secret string, timestamp string, body []byte) http.Header {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	header := http.Header{}
+	header.Set("x-timestamp", timestamp)
+	header.Set("x-signature", hex.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+func TestAirwallexNotifySignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1","name":"payment_intent.succeeded","data":{"object":{"id":"int_1","status":"SUCCEEDED","merchant_order_id":"payment_1","amount":10,"currency":"USD"}}}`)
+	now := time.Now()
+	timestamp := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).UnixMilli(), 10)
+	}
+	tests := []struct {
+		name    string
+		header  http.Header
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			header: signAirwallexNotify("webhook_secret", timestamp(0), body),
+		},
+		{
+			name:   "within tolerance",
+			header: signAirwallexNotify("webhook_secret", timestamp(-4*time.Minute), body),
+		},
+		{
+			name:    "replayed",
+			header:  signAirwallexNotify("webhook_secret", timestamp(-10*time.Minute), body),
+			wantErr: true,
+		},
+		{
+			name:    "from the future",
+			header:  signAirwallexNotify("webhook_secret", timestamp(10*time.Minute), body),
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			header:  signAirwallexNotify("webhook_secret", "yesterday", body),
+			wantErr: true,
+		},
+		{
+			name:    "missing timestamp",
+			header:  signAirwallexNotify("webhook_secret", "", body),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			header:  signAirwallexNotify("other_secret", timestamp(0), body),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp, err := NewAirwallexPaymentProvider("client_id", "api_key", "webhook_secret", EnvironmentSandbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notifyResult, err := pp.Notify(context.Background(), tt.header, body, "payment_1")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidNotifySignature) {
+					t.Fatalf("error = %v, want ErrInvalidNotifySignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if notifyResult.PaymentStatus != PaymentStatePaid {
+				t.Errorf("PaymentStatus = %s, want %s", notifyResult.PaymentStatus, PaymentStatePaid)
+			}
+		})
+	}
+}
@@ -4,7 +4,11 @@ package payment
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/go-pay/gopay"
 	"github.com/go-pay/gopay/alipay"
@@ -14,6 +18,8 @@ import (
 // 实现支付宝支付功能
 type AlipayPaymentProvider struct {
 	Client *alipay.Client // 支付宝客户端
+
+	alipayPublicCert []byte // 支付宝公钥证书，用于通知验签
 }
 
 // NewAlipayPaymentProvider 创建新的支付宝支付提供商实例
@@ -23,20 +29,34 @@ type AlipayPaymentProvider struct {
 //   - appPrivateKey: 应用私钥
 //   - authorityPublicKey: 支付宝公钥
 //   - authorityRootPublicKey: 支付宝根证书
+//   - env: 网关环境，沙箱环境使用支付宝沙箱网关
 // 返回:
 //   - *AlipayPaymentProvider: 支付宝支付提供商实例
-//   - error: 错误信息
-func NewAlipayPaymentProvider(appId string, appCertificate string, appPrivateKey string, authorityPublicKey string, authorityRootPublicKey string) (*AlipayPaymentProvider, error) {
+//   - error: 错误信息，缺少必填参数时包装 ErrProviderNotConfigured
+func NewAlipayPaymentProvider(appId string, appCertificate string, appPrivateKey string, authorityPublicKey string, authorityRootPublicKey string, env Environment) (*AlipayPaymentProvider, error) {
 	// 参数映射说明:
 	// clientId => appId
 	// cert.Certificate => appCertificate
 	// cert.PrivateKey => appPrivateKey
 	// rootCert.Certificate => authorityPublicKey
 	// rootCert.PrivateKey => authorityRootPublicKey
+	err := checkRequiredParams(ProviderTypeAlipay,
+		requiredParam{"appId", appId},
+		requiredParam{"appCertificate", appCertificate},
+		requiredParam{"appPrivateKey", appPrivateKey},
+		requiredParam{"authorityPublicKey", authorityPublicKey},
+		requiredParam{"authorityRootPublicKey", authorityRootPublicKey},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = env.Validate(); err != nil {
+		return nil, err
+	}
 	pp := &AlipayPaymentProvider{}
 
 	// 创建支付宝客户端
-	client, err := alipay.NewClient(appId, appPrivateKey, true)
+	client, err := alipay.NewClient(appId, appPrivateKey, env.IsProd())
 	if err != nil {
 		return nil, err
 	}
@@ -48,16 +68,23 @@ func NewAlipayPaymentProvider(appId string, appCertificate string, appPrivateKey
 	}
 
 	pp.Client = client
+	pp.alipayPublicCert = []byte(authorityPublicKey)
 	return pp, nil
 }
 
 // Pay 执行支付宝支付操作
+// 根据支付环境选择电脑网站、手机网站、App或当面付扫码支付方式
 // 参数:
+//   - ctx: 上下文
 //   - r: 支付请求信息
 // 返回:
 //   - *PayResp: 支付响应信息
 //   - error: 错误信息
-func (pp *AlipayPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *AlipayPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	if err := checkRecurringNotSupported(ProviderTypeAlipay, r); err != nil {
+		return nil, err
+	}
+
 	// 可选：开启调试模式
 	// pp.Client.DebugSwitch = gopay.DebugOn
 	bm := gopay.BodyMap{}
@@ -66,90 +93,271 @@ func (pp *AlipayPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 	pp.Client.SetReturnUrl(r.ReturnUrl)
 	pp.Client.SetNotifyUrl(r.NotifyUrl)
 	
-	// 设置支付参数
-	bm.Set("subject", joinAttachString([]string{r.ProductName, r.ProductDisplayName, r.ProviderName}))
-	bm.Set("out_trade_no", r.PaymentName)
-	bm.Set("total_amount", priceFloat64ToString(r.Price))
-
-	// 创建支付页面
-	payUrl, err := pp.Client.TradePagePay(context.Background(), bm)
+	// 编码订单元数据，通过公用回传参数在异步通知中原样返回，需进行UrlEncode
+	metadata, err := encodeOrderMetadata(r, 0)
 	if err != nil {
 		return nil, err
 	}
-	
-	// 构造支付响应
-	payResp := &PayResp{
-		PayUrl:  payUrl,
-		OrderId: r.PaymentName,
+	passbackParams := url.QueryEscape(metadata)
+	if len(passbackParams) > 512 {
+		return nil, fmt.Errorf("alipay passback_params exceeds 512 characters: %d", len(passbackParams))
+	}
+
+	// 设置支付参数，订单标题会展示给买家
+	bm.Set("subject", r.ProductDisplayName)
+	bm.Set("out_trade_no", r.PaymentName)
+	bm.Set("total_amount", r.Price.String())
+	bm.Set("passback_params", passbackParams)
+
+	// 设置绝对过期时间，买家未登录或未扫码时交易尚未创建、无法关闭，过期后支付链接失效
+	bm.Set("time_expire", time.Now().Add(alipayOrderTimeout).In(alipayTimeLocation).Format(alipayTimeLayout))
+
+	// 根据支付环境选择支付方式
+	switch r.PaymentEnv {
+	case PaymentEnvMobileBrowser:
+		// 手机网站支付，用户中途退出时返回商户网站
+		bm.Set("quit_url", r.getFailUrl())
+		payUrl, err := pp.Client.TradeWapPay(ctx, bm)
+		if err != nil {
+			return nil, wrapAlipayError(err)
+		}
+		payResp := &PayResp{
+			PayUrl:  payUrl,
+			OrderId: r.PaymentName,
+		}
+		return payResp, nil
+	case PaymentEnvApp:
+		// App支付，返回签名后的订单信息字符串，由客户端SDK拉起支付宝
+		orderString, err := pp.Client.TradeAppPay(ctx, bm)
+		if err != nil {
+			return nil, wrapAlipayError(err)
+		}
+		payResp := &PayResp{
+			PayUrl:  "",
+			OrderId: r.PaymentName,
+			AttachInfo: map[string]interface{}{
+				"orderString": orderString,
+			},
+		}
+		return payResp, nil
+	case PaymentEnvQrCode:
+		// 当面付扫码支付，返回二维码内容，买家扫码后交易才会创建
+		precreateRsp, err := pp.Client.TradePrecreate(ctx, bm)
+		if err != nil {
+			return nil, wrapAlipayError(err)
+		}
+		payResp := &PayResp{
+			PayUrl:  precreateRsp.Response.QrCode,
+			OrderId: r.PaymentName,
+		}
+		return payResp, nil
+	default:
+		// 在其他情况下使用电脑网站支付
+		payUrl, err := pp.Client.TradePagePay(ctx, bm)
+		if err != nil {
+			return nil, wrapAlipayError(err)
+		}
+		payResp := &PayResp{
+			PayUrl:  payUrl,
+			OrderId: r.PaymentName,
+		}
+		return payResp, nil
 	}
-	return payResp, nil
 }
 
 // Notify 处理支付宝支付通知
-// 查询订单状态并返回通知结果
+// 使用支付宝公钥证书校验异步通知的RSA2签名，并根据通知内容返回通知结果
 // 参数:
-//   - body: 通知内容
+//   - ctx: 上下文
+//   - header: 通知请求头
+//   - body: 通知内容（application/x-www-form-urlencoded）
 //   - orderId: 订单ID
 // 返回:
 //   - *NotifyResult: 通知结果
 //   - error: 错误信息
-func (pp *AlipayPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	bm := gopay.BodyMap{}
-	bm.Set("out_trade_no", orderId)
-	
-	// 查询交易状态
-	aliRsp, err := pp.Client.TradeQuery(context.Background(), bm)
-	notifyResult := &NotifyResult{}
+func (pp *AlipayPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	// 解析通知参数
+	values, err := url.ParseQuery(string(body))
 	if err != nil {
-		// 解析错误响应
-		errRsp := &alipay.ErrorResponse{}
-		unmarshalErr := json.Unmarshal([]byte(err.Error()), errRsp)
-		if unmarshalErr != nil {
-			return nil, err
-		}
-		// 如果交易不存在，标记为已取消
-		if errRsp.SubCode == "ACQ.TRADE_NOT_EXIST" {
-			notifyResult.PaymentStatus = PaymentStateCanceled
-			return notifyResult, nil
-		}
 		return nil, err
 	}
-	
-	// 根据交易状态设置支付状态
-	switch aliRsp.Response.TradeStatus {
-	case "WAIT_BUYER_PAY": // 等待买家付款
-		notifyResult.PaymentStatus = PaymentStateCreated
-		return notifyResult, nil
-	case "TRADE_CLOSED": // 交易关闭
-		notifyResult.PaymentStatus = PaymentStateTimeout
+	bm, err := alipay.ParseNotifyByURLValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	// 校验签名
+	ok, err := alipay.VerifySignWithCert(pp.alipayPublicCert, bm)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("%w: alipay: %v", ErrInvalidNotifySignature, err)
+	}
+
+	// 校验订单ID
+	orderId, err = getNotifyOrderId(orderId, bm.GetString("out_trade_no"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据交易状态设置支付状态，订单号即支付名称
+	notifyResult := &NotifyResult{
+		OrderId:     orderId,
+		PaymentName: orderId,
+	}
+	tradeStatus := bm.GetString("trade_status")
+	notifyResult.PaymentStatus = getAlipayPaymentState(tradeStatus)
+	if notifyResult.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected alipay trade state: %v", tradeStatus)
 		return notifyResult, nil
-	case "TRADE_SUCCESS": // 交易成功
-		// 继续处理
-	default: // 未知状态
-		notifyResult.PaymentStatus = PaymentStateError
-		notifyResult.NotifyMessage = fmt.Sprintf("unexpected alipay trade state: %v", aliRsp.Response.TradeStatus)
+	}
+	if notifyResult.PaymentStatus != PaymentStatePaid {
 		return notifyResult, nil
 	}
 	
-	// 解析产品信息
-	productDisplayName, productName, providerName, _ := parseAttachString(aliRsp.Response.Subject)
-	
+	// 解析订单金额
+	price, err := ParseMoney(bm.GetString("total_amount"), getAlipayCurrency(bm.GetString("trans_currency")))
+	if err != nil {
+		return nil, err
+	}
+
 	// 构造通知结果
 	notifyResult = &NotifyResult{
-		ProductName:        productName,
-		ProductDisplayName: productDisplayName,
-		ProviderName:       providerName,
-		OrderId:            orderId,
-		PaymentStatus:      PaymentStatePaid,
-		Price:              priceStringToFloat64(aliRsp.Response.TotalAmount),
-		PaymentName:        orderId,
+		OrderId:       orderId,
+		PaymentStatus: PaymentStatePaid,
+		Price:         price,
+		PaymentName:   orderId,
+	}
+
+	// 解析订单元数据，旧订单的产品信息保存在订单标题中
+	if passbackParams := bm.GetString("passback_params"); passbackParams != "" {
+		metadata, err := url.QueryUnescape(passbackParams)
+		if err != nil {
+			return nil, err
+		}
+		applyOrderMetadata(notifyResult, metadata, legacyAttachNameFirst)
+	} else {
+		applyOrderMetadata(notifyResult, bm.GetString("subject"), legacyAttachNameFirst)
 	}
 	return notifyResult, nil
 }
 
+// QueryOrder 查询支付宝订单
+// 调用统一收单交易查询接口，交易不存在时视为已取消
+// 查询接口不返回公用回传参数，产品信息只能从订单标题中获取
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+// 返回:
+//   - *OrderResult: 订单查询结果
+//   - error: 错误信息
+func (pp *AlipayPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	orderResult, err := pp.queryTrade(ctx, orderId)
+	if isAlipayTradeNotExist(err) {
+		// 买家未扫码或未登录时交易尚未创建，视为已取消
+		orderResult = &OrderResult{
+			OrderId:       orderId,
+			PaymentName:   orderId,
+			PaymentStatus: PaymentStateCanceled,
+		}
+		return orderResult, nil
+	}
+	return orderResult, err
+}
+
+// queryTrade 调用统一收单交易查询接口查询交易
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+//
+// 返回:
+//   - *OrderResult: 订单查询结果
+//   - error: 错误信息，交易不存在时 isAlipayTradeNotExist 为true
+func (pp *AlipayPaymentProvider) queryTrade(ctx context.Context, orderId string) (*OrderResult, error) {
+	bm := gopay.BodyMap{}
+	bm.Set("out_trade_no", orderId)
+
+	// 查询交易状态
+	aliRsp, err := pp.Client.TradeQuery(ctx, bm)
+	if err != nil {
+		return nil, wrapAlipayError(err)
+	}
+	trade := aliRsp.Response
+
+	// 解析订单金额
+	currency := getAlipayCurrency(trade.TransCurrency)
+	price, err := ParseMoney(trade.TotalAmount, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// 构造订单查询结果
+	orderResult := &OrderResult{
+		OrderId:       orderId,
+		PaymentName:   orderId,
+		PaymentStatus: getAlipayPaymentState(trade.TradeStatus),
+		VendorState:   trade.TradeStatus,
+		TransactionId: trade.TradeNo,
+		Price:         price,
+		PayerId:       trade.BuyerUserId,
+		PayerName:     trade.BuyerLogonId,
+	}
+
+	// 支付成功后才返回买家实付金额和打款时间
+	if trade.BuyerPayAmount != "" {
+		orderResult.PaidPrice, err = ParseMoney(trade.BuyerPayAmount, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if trade.SendPayDate != "" {
+		orderResult.PayTime, err = time.ParseInLocation(alipayTimeLayout, trade.SendPayDate, alipayTimeLocation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 旧订单的订单标题为"|"分隔的附加字符串，新订单的订单标题即产品显示名称
+	if _, err := decodeOrderMetadata(trade.Subject, legacyAttachNameFirst); err == nil {
+		applyOrderMetadataToOrder(orderResult, trade.Subject, legacyAttachNameFirst)
+	} else {
+		orderResult.ProductDisplayName = trade.Subject
+	}
+	return orderResult, nil
+}
+
+// CloseOrder 关闭支付宝订单
+// 调用统一收单交易关闭接口，只能关闭等待买家付款的交易，关闭后交易状态为 TRADE_CLOSED，即已取消。
+// 买家未登录或未扫码时交易尚未创建，无法关闭，返回错误码为 ErrorCodeNotFound 的错误，支付链接在 time_expire 后失效
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+// 返回:
+//   - error: 错误信息
+func (pp *AlipayPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 检查订单状态
+	orderResult, err := pp.queryTrade(ctx, orderId)
+	if isAlipayTradeNotExist(err) {
+		return newError(ProviderTypeAlipay, ErrorCodeNotFound, "ACQ.TRADE_NOT_EXIST", fmt.Sprintf("alipay trade %s is not created yet and cannot be closed, the payment link expires after %v", orderId, alipayOrderTimeout))
+	}
+	if err != nil {
+		return err
+	}
+	if ok, err := checkOrderClosable(ProviderTypeAlipay, orderResult); !ok {
+		return err
+	}
+
+	// 关闭交易
+	bm := gopay.BodyMap{}
+	bm.Set("out_trade_no", orderId)
+	if _, err = pp.Client.TradeClose(ctx, bm); err != nil {
+		return wrapAlipayError(err)
+	}
+	return nil
+}
+
 // GetInvoice 获取支付宝发票
 // 当前不支持发票功能，返回空字符串
 // 参数:
+//   - ctx: 上下文
 //   - paymentName: 支付名称
 //   - personName: 个人姓名
 //   - personIdCard: 身份证号
@@ -161,10 +369,68 @@ func (pp *AlipayPaymentProvider) Notify(body []byte, orderId string) (*NotifyRes
 // 返回:
 //   - string: 发票信息（空）
 //   - error: 错误信息
-func (pp *AlipayPaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *AlipayPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
 	return "", nil
 }
 
+// Refund 执行支付宝退款操作
+// 未指定退款金额时先查询订单总额进行全额退款
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+// 返回:
+//   - *RefundResp: 退款响应信息
+//   - error: 错误信息
+func (pp *AlipayPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	refundRequestNo, err := getRefundRequestNo(ProviderTypeAlipay, r)
+	if err != nil {
+		return nil, err
+	}
+
+	refundPrice := r.Price
+	if refundPrice.IsZero() {
+		// 全额退款，查询订单总额并扣除已退款金额
+		bm := gopay.BodyMap{}
+		bm.Set("out_trade_no", r.OrderId)
+		aliRsp, err := pp.Client.TradeQuery(ctx, bm)
+		if err != nil {
+			return nil, wrapAlipayError(err)
+		}
+		total, err := ParseMoney(aliRsp.Response.TotalAmount, getAlipayCurrency(aliRsp.Response.TransCurrency))
+		if err != nil {
+			return nil, err
+		}
+		refundPrice, err = getRemainingRefundPrice(ProviderTypeAlipay, total, r.RefundedPrice)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 设置退款参数，out_request_no 用于幂等控制和部分退款
+	bm := gopay.BodyMap{}
+	bm.Set("out_trade_no", r.OrderId)
+	bm.Set("refund_amount", refundPrice.String())
+	bm.Set("out_request_no", refundRequestNo)
+	if r.Reason != "" {
+		bm.Set("refund_reason", r.Reason)
+	}
+
+	// 发起退款
+	_, err = pp.Client.TradeRefund(ctx, bm)
+	if err != nil {
+		return nil, wrapAlipayError(err)
+	}
+
+	// 支付宝退款接口为同步返回，调用成功即表示退款成功
+	// 支付宝没有独立的退款单号，以退款请求号作为退款单号；响应中的 refund_fee 为累计退款金额，返回本次退款金额
+	refundResp := &RefundResp{
+		RefundId:    refundRequestNo,
+		RefundState: RefundStateSucceeded,
+		Price:       refundPrice,
+	}
+	return refundResp, nil
+}
+
 // GetResponseError 获取支付宝响应错误信息
 // 根据错误状态返回相应的字符串
 // 参数:
@@ -178,3 +444,115 @@ func (pp *AlipayPaymentProvider) GetResponseError(err error) string {
 		return "fail" // 失败
 	}
 }
+
+// isAlipayTradeNotExist 判断错误是否为交易不存在
+// 参数:
+//   - err: 错误信息
+//
+// 返回:
+//   - bool: 支付宝返回 ACQ.TRADE_NOT_EXIST 时为true
+func isAlipayTradeNotExist(err error) bool {
+	var payErr *Error
+	return errors.As(err, &payErr) && payErr.VendorCode == "ACQ.TRADE_NOT_EXIST"
+}
+
+// alipayOrderTimeout 支付宝订单的支付时限
+const alipayOrderTimeout = 30 * time.Minute
+
+// alipayTimeLayout 支付宝接口返回的时间格式
+const alipayTimeLayout = "2006-01-02 15:04:05"
+
+// alipayTimeLocation 支付宝接口返回时间所在的时区，即北京时间
+var alipayTimeLocation = time.FixedZone("CST", 8*60*60)
+
+// getAlipayPaymentState 根据支付宝交易状态获取支付状态
+// 参数:
+//   - tradeStatus: 支付宝交易状态
+//
+// 返回:
+//   - PaymentState: 支付状态，未知交易状态返回 PaymentStateError
+func getAlipayPaymentState(tradeStatus string) PaymentState {
+	switch tradeStatus {
+	case "WAIT_BUYER_PAY": // 等待买家付款
+		return PaymentStateCreated
+	case "TRADE_CLOSED": // 交易关闭，包括超时关闭、主动关闭和全额退款
+		return PaymentStateCanceled
+	case "TRADE_SUCCESS", "TRADE_FINISHED": // 交易成功；交易结束，不可退款
+		return PaymentStatePaid
+	default: // 未知状态
+		return PaymentStateError
+	}
+}
+
+// getAlipayCurrency 获取支付宝订单币种
+// 参数:
+//   - transCurrency: 交易币种
+//
+// 返回:
+//   - string: 币种代码，未指定交易币种时为人民币
+func getAlipayCurrency(transCurrency string) string {
+	if transCurrency == "" {
+		return "CNY"
+	}
+	return transCurrency
+}
+
+// alipayErrorCodes 支付宝业务错误码（sub_code）与支付错误码的映射
+var alipayErrorCodes = map[string]ErrorCode{
+	"ACQ.TRADE_NOT_EXIST":                   ErrorCodeNotFound,
+	"ACQ.TRADE_HAS_SUCCESS":                 ErrorCodeDuplicateOrder,
+	"ACQ.TRADE_HAS_FINISHED":                ErrorCodeDuplicateOrder,
+	"ACQ.TRADE_HAS_CLOSE":                   ErrorCodeOrderClosed,
+	"ACQ.TRADE_STATUS_ERROR":                ErrorCodeOrderClosed,
+	"ACQ.BUYER_BALANCE_NOT_ENOUGH":          ErrorCodeInsufficientFunds,
+	"ACQ.BUYER_BANKCARD_BALANCE_NOT_ENOUGH": ErrorCodeInsufficientFunds,
+	"ACQ.SELLER_BALANCE_NOT_ENOUGH":         ErrorCodeInsufficientFunds,
+	"ACQ.PAYMENT_AUTH_CODE_INVALID":         ErrorCodeCardDeclined,
+	"ACQ.INVALID_PARAMETER":                 ErrorCodeInvalidRequest,
+	"ACQ.REFUND_AMT_NOT_EQUAL_TOTAL":        ErrorCodeInvalidRequest,
+	"ACQ.SYSTEM_ERROR":                      ErrorCodeProviderUnavailable,
+	"aop.ACQ.SYSTEM_ERROR":                  ErrorCodeProviderUnavailable,
+	"isp.unknow-error":                      ErrorCodeProviderUnavailable,
+	"isv.invalid-signature":                 ErrorCodeAuthFailed,
+	"isv.invalid-app-id":                    ErrorCodeAuthFailed,
+	"isv.insufficient-isv-permissions":      ErrorCodeAuthFailed,
+}
+
+// alipayGatewayErrorCodes 支付宝网关返回码（code）与支付错误码的映射
+var alipayGatewayErrorCodes = map[string]ErrorCode{
+	"20000": ErrorCodeProviderUnavailable, // 服务不可用
+	"20001": ErrorCodeAuthFailed,          // 授权权限不足
+	"40001": ErrorCodeInvalidRequest,      // 缺少必选参数
+	"40002": ErrorCodeInvalidRequest,      // 非法的参数
+	"40006": ErrorCodeAuthFailed,          // 权限不足
+}
+
+// wrapAlipayError 将支付宝错误映射为支付错误
+// gopay 将支付宝的错误响应序列化为JSON放在错误信息中
+// 参数:
+//   - err: gopay 返回的错误
+//
+// 返回:
+//   - error: 支付错误
+func wrapAlipayError(err error) error {
+	errRsp := &alipay.ErrorResponse{}
+	if json.Unmarshal([]byte(err.Error()), errRsp) != nil || errRsp.Code == "" {
+		return wrapError(ProviderTypeAlipay, err)
+	}
+
+	code, ok := alipayErrorCodes[errRsp.SubCode]
+	if !ok {
+		code, ok = alipayGatewayErrorCodes[errRsp.Code]
+	}
+	if !ok {
+		code = ErrorCodeUnknown
+	}
+
+	vendorCode, vendorMessage := errRsp.SubCode, errRsp.SubMsg
+	if vendorCode == "" {
+		vendorCode, vendorMessage = errRsp.Code, errRsp.Msg
+	}
+	e := newError(ProviderTypeAlipay, code, vendorCode, vendorMessage)
+	e.Err = err
+	return e
+}
@@ -2,7 +2,9 @@
 package payment
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 )
 
 // BalancePaymentProvider 余额支付提供者结构体
@@ -16,9 +18,10 @@ func NewBalancePaymentProvider() (*BalancePaymentProvider, error) {
 }
 
 // Pay 处理余额支付请求
+// ctx: 上下文
 // r: 支付请求参数
 // 返回支付响应和可能的错误
-func (pp *BalancePaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *BalancePaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
 	// 从支付者ID中获取所有者信息
 	owner, _ := GetOwnerAndNameFromId(r.PayerId)
 	return &PayResp{
@@ -28,17 +31,42 @@ func (pp *BalancePaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 }
 
 // Notify 处理余额支付回调通知
+// ctx: 上下文
+// header: 回调请求头
 // body: 回调请求体
 // orderId: 订单ID
 // 返回通知结果和可能的错误
-func (pp *BalancePaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
+func (pp *BalancePaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
 	// 余额支付直接返回支付成功状态
 	return &NotifyResult{
 		PaymentStatus: PaymentStatePaid, // 支付状态为已支付
 	}, nil
 }
 
+// QueryOrder 查询余额支付订单
+// ctx: 上下文
+// orderId: 订单ID
+// 返回订单查询结果和可能的错误
+func (pp *BalancePaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	// 余额支付在下单时即完成扣款，直接返回支付成功状态
+	return &OrderResult{
+		OrderId:       orderId,          // 订单ID
+		PaymentName:   orderId,          // 支付名称
+		PaymentStatus: PaymentStatePaid, // 支付状态为已支付
+	}, nil
+}
+
+// CloseOrder 关闭余额支付订单
+// ctx: 上下文
+// orderId: 订单ID
+// 返回可能的错误
+func (pp *BalancePaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 余额支付在下单时即完成扣款，订单不能关闭
+	return newError(ProviderTypeBalance, ErrorCodeDuplicateOrder, "", fmt.Sprintf("order %s is already paid", orderId))
+}
+
 // GetInvoice 获取发票信息
+// ctx: 上下文
 // paymentName: 支付名称
 // personName: 个人姓名
 // personIdCard: 身份证号
@@ -48,11 +76,28 @@ func (pp *BalancePaymentProvider) Notify(body []byte, orderId string) (*NotifyRe
 // invoiceTitle: 发票抬头
 // invoiceTaxId: 税号
 // 返回发票URL和可能的错误
-func (pp *BalancePaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *BalancePaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
 	// 余额支付暂不支持发票功能
 	return "", nil
 }
 
+// Refund 处理余额退款请求
+// ctx: 上下文
+// r: 退款请求参数
+// 返回退款响应和可能的错误
+func (pp *BalancePaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	refundNo, err := getRefundRequestNo(ProviderTypeBalance, r)
+	if err != nil {
+		return nil, err
+	}
+	// 余额退款由调用方直接退回账户余额，此处直接返回退款成功
+	return &RefundResp{
+		RefundId:    refundNo,             // 退款单号
+		RefundState: RefundStateSucceeded, // 退款状态为成功
+		Price:       r.Price,              // 退款金额
+	}, nil
+}
+
 // GetResponseError 获取响应错误信息
 // err: 错误对象
 // 返回错误描述字符串
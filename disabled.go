@@ -0,0 +1,134 @@
+// Package payment 支付相关功能
+package payment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// DisabledPaymentProvider 已禁用的支付提供商
+// 用于凭证未配置或暂停使用的支付方式，所有方法都返回 ErrProviderNotConfigured，不会访问支付平台
+type DisabledPaymentProvider struct {
+	ProviderType string // 提供商类型名称，例如 "WeChat Pay"
+	Reason       string // 禁用原因，会包含在错误信息中
+}
+
+// NewDisabledPaymentProvider 创建已禁用的支付提供商实例
+// 参数:
+//   - providerType: 提供商类型名称
+//   - reason: 禁用原因，可以为空
+//
+// 返回:
+//   - *DisabledPaymentProvider: 已禁用的支付提供商实例
+func NewDisabledPaymentProvider(providerType string, reason string) *DisabledPaymentProvider {
+	pp := &DisabledPaymentProvider{
+		ProviderType: providerType,
+		Reason:       reason,
+	}
+	return pp
+}
+
+// Pay 执行支付操作
+// 参数:
+//   - ctx: 上下文
+//   - r: 支付请求信息
+//
+// 返回:
+//   - *PayResp: 始终为nil
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	return nil, pp.err()
+}
+
+// Notify 处理支付通知
+// 参数:
+//   - ctx: 上下文
+//   - header: 通知请求头
+//   - body: 通知内容
+//   - orderId: 订单ID
+//
+// 返回:
+//   - *NotifyResult: 始终为nil
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	return nil, pp.err()
+}
+
+// QueryOrder 查询订单
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+//
+// 返回:
+//   - *OrderResult: 始终为nil
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	return nil, pp.err()
+}
+
+// CloseOrder 关闭订单
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+//
+// 返回:
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	return pp.err()
+}
+
+// GetInvoice 获取发票
+// 参数:
+//   - ctx: 上下文
+//   - paymentName: 支付名称
+//   - personName: 个人姓名
+//   - personIdCard: 身份证号
+//   - personEmail: 邮箱
+//   - personPhone: 电话
+//   - invoiceType: 发票类型
+//   - invoiceTitle: 发票抬头
+//   - invoiceTaxId: 税号
+//
+// 返回:
+//   - string: 始终为空
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+	return "", pp.err()
+}
+
+// Refund 执行退款操作
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+//
+// 返回:
+//   - *RefundResp: 始终为nil
+//   - error: ErrProviderNotConfigured
+func (pp *DisabledPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	return nil, pp.err()
+}
+
+// GetResponseError 获取响应错误信息
+// 参数:
+//   - err: 错误对象
+//
+// 返回:
+//   - string: 错误响应字符串
+func (pp *DisabledPaymentProvider) GetResponseError(err error) string {
+	if err == nil {
+		return "success" // 成功
+	} else {
+		return "fail" // 失败
+	}
+}
+
+// err 构造包含提供商类型和禁用原因的错误
+// 返回:
+//   - error: 包装了 ErrProviderNotConfigured 的错误
+func (pp *DisabledPaymentProvider) err() error {
+	if pp.Reason == "" {
+		return fmt.Errorf("%w: %s is disabled", ErrProviderNotConfigured, pp.ProviderType)
+	}
+	return fmt.Errorf("%w: %s is disabled: %s", ErrProviderNotConfigured, pp.ProviderType, pp.Reason)
+}
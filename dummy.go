@@ -1,6 +1,11 @@
 // Package payment 支付相关功能
 package payment
 
+import (
+	"context"
+	"net/http"
+)
+
 // DummyPaymentProvider 虚拟支付提供商
 // 用于测试和开发环境的模拟支付
 type DummyPaymentProvider struct{}
@@ -17,11 +22,12 @@ func NewDummyPaymentProvider() (*DummyPaymentProvider, error) {
 // Pay 执行虚拟支付操作
 // 直接返回成功响应，用于测试
 // 参数:
+//   - ctx: 上下文
 //   - r: 支付请求信息
 // 返回:
 //   - *PayResp: 支付响应信息
 //   - error: 错误信息
-func (pp *DummyPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *DummyPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
 	return &PayResp{
 		PayUrl: r.ReturnUrl,
 	}, nil
@@ -30,20 +36,50 @@ func (pp *DummyPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 // Notify 处理虚拟支付通知
 // 直接返回支付成功状态
 // 参数:
+//   - ctx: 上下文
+//   - header: 通知请求头
 //   - body: 通知内容
 //   - orderId: 订单ID
 // 返回:
 //   - *NotifyResult: 通知结果
 //   - error: 错误信息
-func (pp *DummyPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
+func (pp *DummyPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
 	return &NotifyResult{
 		PaymentStatus: PaymentStatePaid,
 	}, nil
 }
 
+// QueryOrder 查询虚拟支付订单
+// 直接返回支付成功状态
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+// 返回:
+//   - *OrderResult: 订单查询结果
+//   - error: 错误信息
+func (pp *DummyPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	return &OrderResult{
+		OrderId:       orderId,
+		PaymentName:   orderId,
+		PaymentStatus: PaymentStatePaid,
+	}, nil
+}
+
+// CloseOrder 关闭虚拟支付订单
+// 直接返回成功
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+// 返回:
+//   - error: 错误信息
+func (pp *DummyPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	return nil
+}
+
 // GetInvoice 获取虚拟发票
 // 返回空字符串，不支持发票功能
 // 参数:
+//   - ctx: 上下文
 //   - paymentName: 支付名称
 //   - personName: 个人姓名
 //   - personIdCard: 身份证号
@@ -55,10 +91,30 @@ func (pp *DummyPaymentProvider) Notify(body []byte, orderId string) (*NotifyResu
 // 返回:
 //   - string: 发票信息（空）
 //   - error: 错误信息
-func (pp *DummyPaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *DummyPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
 	return "", nil
 }
 
+// Refund 执行虚拟退款操作
+// 直接返回退款成功，用于测试
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+// 返回:
+//   - *RefundResp: 退款响应信息
+//   - error: 错误信息
+func (pp *DummyPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	refundNo, err := getRefundRequestNo(ProviderTypeDummy, r)
+	if err != nil {
+		return nil, err
+	}
+	return &RefundResp{
+		RefundId:    refundNo,
+		RefundState: RefundStateSucceeded,
+		Price:       r.Price,
+	}, nil
+}
+
 // GetResponseError 获取虚拟响应错误信息
 // 返回空字符串
 // 参数:
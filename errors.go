@@ -0,0 +1,131 @@
+// Package payment 支付相关功能
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrorCode 支付错误码类型
+// 与具体支付平台无关的稳定错误分类
+type ErrorCode string
+
+// 支付错误码常量定义
+const (
+	ErrorCodeUnknown             ErrorCode = "Unknown"             // 未知错误
+	ErrorCodeInvalidRequest      ErrorCode = "InvalidRequest"      // 请求参数错误
+	ErrorCodeAuthFailed          ErrorCode = "AuthFailed"          // 鉴权失败，例如密钥或证书错误
+	ErrorCodeNotFound            ErrorCode = "NotFound"            // 订单或资源不存在
+	ErrorCodeDuplicateOrder      ErrorCode = "DuplicateOrder"      // 订单重复或已支付
+	ErrorCodeOrderClosed         ErrorCode = "OrderClosed"         // 订单已关闭
+	ErrorCodeInsufficientFunds   ErrorCode = "InsufficientFunds"   // 余额不足
+	ErrorCodeCardDeclined        ErrorCode = "CardDeclined"        // 银行卡被拒绝
+	ErrorCodeRateLimited         ErrorCode = "RateLimited"         // 请求频率超限
+	ErrorCodeProviderUnavailable ErrorCode = "ProviderUnavailable" // 支付平台不可用或网络错误
+)
+
+// Error 支付错误
+// 各提供商将支付平台返回的错误映射为该类型，调用方可通过 errors.As 获取错误码
+type Error struct {
+	Code          ErrorCode // 错误码
+	Retryable     bool      // 是否可以重试
+	Provider      string    // 提供商类型名称，例如 "Alipay"
+	VendorCode    string    // 支付平台原始错误码
+	VendorMessage string    // 支付平台原始错误信息
+	Err           error     // 底层错误
+}
+
+// Error 实现 error 接口
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Provider, e.Code)
+	if e.VendorCode != "" {
+		msg += fmt.Sprintf(" (%s)", e.VendorCode)
+	}
+	if e.VendorMessage != "" {
+		msg += ": " + e.VendorMessage
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap 返回底层错误
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError 创建支付错误
+// 是否可以重试由错误码决定
+// 参数:
+//   - provider: 提供商类型名称
+//   - code: 错误码
+//   - vendorCode: 支付平台原始错误码
+//   - vendorMessage: 支付平台原始错误信息
+//
+// 返回:
+//   - *Error: 支付错误
+func newError(provider string, code ErrorCode, vendorCode string, vendorMessage string) *Error {
+	return &Error{
+		Code:          code,
+		Retryable:     code == ErrorCodeRateLimited || code == ErrorCodeProviderUnavailable,
+		Provider:      provider,
+		VendorCode:    vendorCode,
+		VendorMessage: vendorMessage,
+	}
+}
+
+// wrapError 将无法识别为支付平台业务错误的错误包装为支付错误
+// 网络错误和超时视为支付平台不可用，可以重试；上下文取消不可重试
+// 参数:
+//   - provider: 提供商类型名称
+//   - err: 原始错误
+//
+// 返回:
+//   - error: 支付错误，err 为 nil 或已是支付错误时原样返回
+func wrapError(provider string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var payErr *Error
+	if errors.As(err, &payErr) {
+		return err
+	}
+
+	code := ErrorCodeUnknown
+	var netErr net.Error
+	if !errors.Is(err, context.Canceled) && (errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)) {
+		code = ErrorCodeProviderUnavailable
+	}
+	e := newError(provider, code, "", "")
+	e.Err = err
+	return e
+}
+
+// getErrorCodeByHTTPStatus 根据HTTP状态码获取错误码
+// 用于支付平台错误码无法识别时的兜底分类
+// 参数:
+//   - status: HTTP状态码
+//
+// 返回:
+//   - ErrorCode: 错误码
+func getErrorCodeByHTTPStatus(status int) ErrorCode {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorCodeAuthFailed
+	case status == http.StatusNotFound:
+		return ErrorCodeNotFound
+	case status == http.StatusConflict:
+		return ErrorCodeDuplicateOrder
+	case status == http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case status >= http.StatusInternalServerError:
+		return ErrorCodeProviderUnavailable
+	case status >= http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	default:
+		return ErrorCodeUnknown
+	}
+}
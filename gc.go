@@ -3,6 +3,7 @@ package payment
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -10,10 +11,14 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/casdoor/casdoor/util"
 )
 
+// gcCurrency GC支付仅支持人民币
+const gcCurrency = "CNY"
+
 // GcPaymentProvider GC支付提供者结构体
 type GcPaymentProvider struct {
 	Xmpch     string // 商户号
@@ -47,17 +52,17 @@ type GcPayRespInfo struct {
 
 // GcNotifyRespInfo GC通知响应信息结构体
 type GcNotifyRespInfo struct {
-	Xmpch      string  `json:"xmpch"`      // 商户号
-	OrderDate  string  `json:"orderdate"`  // 订单日期
-	OrderNo    string  `json:"orderno"`    // 订单号
-	Amount     float64 `json:"amount"`     // 金额
-	Jylsh      string  `json:"jylsh"`      // 交易流水号
-	TradeNo    string  `json:"tradeno"`    // 交易号
-	PayMethod  string  `json:"paymethod"`  // 支付方式
-	OrderState string  `json:"orderstate"` // 订单状态
-	ReturnType string  `json:"return_type"` // 返回类型
-	PayerId    string  `json:"payerid"`    // 支付者ID
-	PayerName  string  `json:"payername"`  // 支付者姓名
+	Xmpch      string      `json:"xmpch"`       // 商户号
+	OrderDate  string      `json:"orderdate"`   // 订单日期
+	OrderNo    string      `json:"orderno"`     // 订单号
+	Amount     json.Number `json:"amount"`      // 金额
+	Jylsh      string      `json:"jylsh"`       // 交易流水号
+	TradeNo    string      `json:"tradeno"`     // 交易号
+	PayMethod  string      `json:"paymethod"`   // 支付方式
+	OrderState string      `json:"orderstate"`  // 订单状态
+	ReturnType string      `json:"return_type"` // 返回类型
+	PayerId    string      `json:"payerid"`     // 支付者ID
+	PayerName  string      `json:"payername"`   // 支付者姓名
 }
 
 // GcRequestBody GC请求体结构体
@@ -105,24 +110,55 @@ type GcInvoiceRespInfo struct {
 	Content   string `json:"content"`   // 内容
 }
 
+// GcInvoiceState GC电子票据开具状态
+type GcInvoiceState string
+
+// GC电子票据开具状态常量定义
+const (
+	GcInvoiceStatePending GcInvoiceState = "Pending" // 开票中
+	GcInvoiceStateIssued  GcInvoiceState = "Issued"  // 已开具
+	GcInvoiceStateFailed  GcInvoiceState = "Failed"  // 开票失败
+)
+
+// GcInvoice GC电子票据
+type GcInvoice struct {
+	InvoiceId string         // 发票ID，即业务号（支付名称）
+	State     GcInvoiceState // 开具状态
+	Url       string         // 电子票据URL，开具后才有值
+	EbillCode string         // 电子票据代码
+	EbillNo   string         // 电子票据号码
+	CheckCode string         // 校验码
+	Message   string         // 开票失败原因
+}
+
 // NewGcPaymentProvider 创建新的GC支付提供者实例
 // clientId: 客户端ID（商户号）
 // clientSecret: 客户端密钥
 // host: 主机地址
-// 返回GC支付提供者实例
-func NewGcPaymentProvider(clientId string, clientSecret string, host string) *GcPaymentProvider {
+// 返回GC支付提供者实例和可能的错误，缺少必填参数时错误包装 ErrProviderNotConfigured
+func NewGcPaymentProvider(clientId string, clientSecret string, host string) (*GcPaymentProvider, error) {
+	// 检查必要参数
+	err := checkRequiredParams(ProviderTypeGc,
+		requiredParam{"clientId", clientId},
+		requiredParam{"clientSecret", clientSecret},
+		requiredParam{"host", host},
+	)
+	if err != nil {
+		return nil, err
+	}
 	pp := &GcPaymentProvider{}
 
 	pp.Xmpch = clientId      // 设置商户号
 	pp.SecretKey = clientSecret // 设置密钥
 	pp.Host = host           // 设置主机地址
-	return pp
+	return pp, nil
 }
 
 // doPost 执行POST请求
+// ctx: 上下文
 // postBytes: 请求体字节数组
 // 返回响应字节数组和可能的错误
-func (pp *GcPaymentProvider) doPost(postBytes []byte) ([]byte, error) {
+func (pp *GcPaymentProvider) doPost(ctx context.Context, postBytes []byte) ([]byte, error) {
 	client := &http.Client{}
 
 	var resp *http.Response
@@ -133,7 +169,7 @@ func (pp *GcPaymentProvider) doPost(postBytes []byte) ([]byte, error) {
 	body := bytes.NewReader(postBytes)
 
 	// 创建POST请求
-	req, err := http.NewRequest("POST", pp.Host, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", pp.Host, body)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +180,7 @@ func (pp *GcPaymentProvider) doPost(postBytes []byte) ([]byte, error) {
 	// 执行请求
 	resp, err = client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ProviderTypeGc, err)
 	}
 	// 确保响应体被关闭
 	defer func(Body io.ReadCloser) {
@@ -157,110 +193,170 @@ func (pp *GcPaymentProvider) doPost(postBytes []byte) ([]byte, error) {
 	// 读取响应体
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ProviderTypeGc, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newError(ProviderTypeGc, getErrorCodeByHTTPStatus(resp.StatusCode), "", string(respBytes))
 	}
 	return respBytes, nil
 }
 
-// Pay 处理GC支付请求
-// r: 支付请求参数
-// 返回支付响应和可能的错误
-func (pp *GcPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
-	// 构建支付请求信息
-	payReqInfo := GcPayReqInfo{
-		OrderDate: util.GenerateSimpleTimeId(), // 生成订单日期
-		OrderNo:   r.PaymentName,               // 订单号
-		Amount:    getPriceString(r.Price),     // 金额
-		Xmpch:     pp.Xmpch,                    // 商户号
-		Body:      r.ProductDisplayName,        // 商品描述
-		ReturnUrl: r.ReturnUrl,                 // 返回URL
-		NotifyUrl: r.NotifyUrl,                 // 通知URL
-		Remark1:   r.PayerName,                 // 备注1：支付者姓名
-		Remark2:   r.ProductName,               // 备注2：产品名称
+// getGcPriceString 将金额转换为GC接口使用的价格字符串
+// 去除末尾的零和小数点，例如 "12.50" 转换为 "12.5"
+// price: 金额
+// 返回价格字符串
+func getGcPriceString(price Money) string {
+	priceString := price.String()
+	if strings.Contains(priceString, ".") {
+		priceString = strings.TrimRight(strings.TrimRight(priceString, "0"), ".")
 	}
+	return priceString
+}
+
+// getSign 计算GC请求签名
+// 按参数名排序拼接后追加密钥，计算MD5并转为大写
+// body: 请求体
+// 返回签名字符串
+func (pp *GcPaymentProvider) getSign(body GcRequestBody) string {
+	params := fmt.Sprintf("data=%s&op=%s&requesttime=%s&version=%s&xmpch=%s%s", body.Data, body.Op, body.RequestTime, body.Version, body.Xmpch, pp.SecretKey)
+	return strings.ToUpper(util.GetMd5Hash(params))
+}
 
-	// 序列化支付请求信息
-	b, err := json.Marshal(payReqInfo)
+// doOp 执行GC接口操作
+// 负责业务数据的Base64编码、签名、发送请求以及响应数据的解码
+// ctx: 上下文
+// op: 操作类型
+// reqInfo: 请求业务数据
+// respInfo: 响应业务数据的解析目标
+// 返回可能的错误
+func (pp *GcPaymentProvider) doOp(ctx context.Context, op string, reqInfo interface{}, respInfo interface{}) error {
+	// 序列化请求业务数据
+	b, err := json.Marshal(reqInfo)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// 构建请求体
 	body := GcRequestBody{
-		Op:          "OrderCreate",                           // 操作类型：创建订单
-		Xmpch:       pp.Xmpch,                               // 商户号
-		Version:     "1.4",                                  // 版本号
-		Data:        base64.StdEncoding.EncodeToString(b),   // Base64编码的数据
-		RequestTime: util.GenerateSimpleTimeId(),            // 请求时间
+		Op:          op,                                   // 操作类型
+		Xmpch:       pp.Xmpch,                             // 商户号
+		Version:     "1.4",                                // 版本号
+		Data:        base64.StdEncoding.EncodeToString(b), // Base64编码的数据
+		RequestTime: util.GenerateSimpleTimeId(),          // 请求时间
 	}
-
-	// 生成签名参数字符串
-	params := fmt.Sprintf("data=%s&op=%s&requesttime=%s&version=%s&xmpch=%s%s", body.Data, body.Op, body.RequestTime, body.Version, body.Xmpch, pp.SecretKey)
-	// 计算MD5签名并转为大写
-	body.Sign = strings.ToUpper(util.GetMd5Hash(params))
+	body.Sign = pp.getSign(body)
 
 	// 序列化请求体
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// 发送POST请求
-	respBytes, err := pp.doPost(bodyBytes)
+	respBytes, err := pp.doPost(ctx, bodyBytes)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// 解析响应体
 	var respBody GcResponseBody
 	err = json.Unmarshal(respBytes, &respBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// 检查返回码
 	if respBody.ReturnCode != "SUCCESS" {
-		return nil, fmt.Errorf("%s: %s", respBody.ReturnCode, respBody.ReturnMsg)
+		// GC未公开业务错误码，无法细分错误类型
+		return newError(ProviderTypeGc, ErrorCodeUnknown, respBody.ReturnCode, respBody.ReturnMsg)
 	}
 
 	// 解码响应数据
-	payRespInfoBytes, err := base64.StdEncoding.DecodeString(respBody.Data)
+	respInfoBytes, err := base64.StdEncoding.DecodeString(respBody.Data)
 	if err != nil {
+		return err
+	}
+
+	// 解析响应业务数据
+	return json.Unmarshal(respInfoBytes, respInfo)
+}
+
+// Pay 处理GC支付请求
+// ctx: 上下文
+// r: 支付请求参数
+// 返回支付响应和可能的错误
+func (pp *GcPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	if err := checkRecurringNotSupported(ProviderTypeGc, r); err != nil {
 		return nil, err
 	}
 
-	// 解析支付响应信息
+	// 构建支付请求信息
+	payReqInfo := GcPayReqInfo{
+		OrderDate: util.GenerateSimpleTimeId(), // 生成订单日期
+		OrderNo:   r.PaymentName,               // 订单号
+		Amount:    getGcPriceString(r.Price),   // 金额
+		Xmpch:     pp.Xmpch,                    // 商户号
+		Body:      r.ProductDisplayName,        // 商品描述
+		ReturnUrl: r.ReturnUrl,                 // 返回URL
+		NotifyUrl: r.NotifyUrl,                 // 通知URL
+		Remark1:   r.PayerName,                 // 备注1：支付者姓名
+		Remark2:   r.ProductName,               // 备注2：产品名称
+	}
+
+	// 创建订单
 	var payRespInfo GcPayRespInfo
-	err = json.Unmarshal(payRespInfoBytes, &payRespInfo)
+	err := pp.doOp(ctx, "OrderCreate", payReqInfo, &payRespInfo)
 	if err != nil {
 		return nil, err
 	}
 	// 构建支付响应
 	payResp := &PayResp{
-		PayUrl: payRespInfo.PayUrl, // 支付URL
+		PayUrl:  payRespInfo.PayUrl, // 支付URL
+		OrderId: payRespInfo.Jylsh,  // 交易流水号
 	}
 	return payResp, nil
 }
 
 // Notify 处理GC支付的回调通知
+// 使用与请求相同的MD5签名方式校验通知签名，通知内容缺少必要参数时返回错误
+// ctx: 上下文
+// header: 通知请求头
 // body: 通知请求体字节数组
-// orderId: 订单ID
+// orderId: 订单ID，即Pay返回的交易流水号；旧订单可以使用订单号
 // 返回通知结果和可能的错误
-func (pp *GcPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	reqBody := GcRequestBody{}
+func (pp *GcPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
 	// 解析URL编码的请求体
 	m, err := url.ParseQuery(string(body))
 	if err != nil {
 		return nil, err
 	}
 
-	// 提取请求参数
-	reqBody.Op = m["op"][0]              // 操作类型
-	reqBody.Xmpch = m["xmpch"][0]        // 商户号
-	reqBody.Version = m["version"][0]    // 版本号
-	reqBody.Data = m["data"][0]          // 数据
-	reqBody.RequestTime = m["requesttime"][0] // 请求时间
-	reqBody.Sign = m["sign"][0]          // 签名
+	// 提取请求参数，所有参数都参与签名，缺少任何一个都无法验签
+	missing := make([]string, 0)
+	for _, name := range []string{"op", "xmpch", "version", "data", "requesttime", "sign"} {
+		if m.Get(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("gc notification missing parameters: %s", strings.Join(missing, ", "))
+	}
+	reqBody := GcRequestBody{
+		Op:          m.Get("op"),          // 操作类型
+		Xmpch:       m.Get("xmpch"),       // 商户号
+		Version:     m.Get("version"),     // 版本号
+		Data:        m.Get("data"),        // 数据
+		RequestTime: m.Get("requesttime"), // 请求时间
+		Sign:        m.Get("sign"),        // 签名
+	}
+
+	// 校验签名，签名方式与请求一致
+	if !strings.EqualFold(pp.getSign(reqBody), reqBody.Sign) {
+		return nil, fmt.Errorf("%w: gc", ErrInvalidNotifySignature)
+	}
+	if reqBody.Xmpch != pp.Xmpch {
+		return nil, fmt.Errorf("gc notification merchant mismatch: expected %s, got %s", pp.Xmpch, reqBody.Xmpch)
+	}
 
 	// 解码Base64数据
 	notifyReqInfoBytes, err := base64.StdEncoding.DecodeString(reqBody.Data)
@@ -275,33 +371,86 @@ func (pp *GcPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult,
 		return nil, err
 	}
 
-	// 初始化响应字段
-	providerName := ""       // 提供者名称
-	productName := ""        // 产品名称
+	// 校验订单ID，旧订单以订单号作为订单ID
+	notifyOrderId := notifyRespInfo.Jylsh
+	if orderId != "" && orderId == notifyRespInfo.OrderNo {
+		notifyOrderId = orderId
+	}
+	orderId, err = getNotifyOrderId(orderId, notifyOrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 构建通知结果
+	orderResult, err := notifyRespInfo.toOrderResult(orderId)
+	if err != nil {
+		return nil, err
+	}
+	return orderResult.toNotifyResult(), nil
+}
+
+// QueryOrder 查询GC支付订单
+// GC网关暂未提供订单查询接口，订单状态以支付通知为准
+// ctx: 上下文
+// orderId: 订单ID
+// 返回订单查询结果和可能的错误
+func (pp *GcPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	return nil, fmt.Errorf("%w: gc order query", ErrNotSupported)
+}
 
-	productDisplayName := "" // 产品显示名称
-	paymentName := notifyRespInfo.OrderNo // 支付名称（订单号）
-	price := notifyRespInfo.Amount        // 价格
+// toOrderResult 根据通知的订单信息构造订单查询结果
+// orderId: 订单ID
+// 返回订单查询结果和可能的错误
+func (info *GcNotifyRespInfo) toOrderResult(orderId string) (*OrderResult, error) {
+	orderResult := &OrderResult{
+		OrderId:       orderId,                            // 订单ID
+		PaymentName:   info.OrderNo,                       // 支付名称（订单号）
+		PaymentStatus: getGcPaymentState(info.OrderState), // 支付状态
+		VendorState:   info.OrderState,                    // GC订单状态
+		TransactionId: info.TradeNo,                       // 交易号
+		PayerId:       info.PayerId,                       // 支付者ID
+		PayerName:     info.PayerName,                     // 支付者姓名
+	}
+	if info.Amount != "" {
+		price, err := ParseMoney(info.Amount.String(), gcCurrency)
+		if err != nil {
+			return nil, err
+		}
+		orderResult.Price = price
+		if orderResult.PaymentStatus == PaymentStatePaid {
+			orderResult.PaidPrice = price
+		}
+	}
+	// 订单日期由Pay按本地时间生成
+	if createTime, err := time.ParseInLocation("20060102150405", info.OrderDate, time.Local); err == nil {
+		orderResult.CreateTime = createTime
+	}
+	return orderResult, nil
+}
 
-	// 检查订单状态，"1"表示支付成功
-	if notifyRespInfo.OrderState != "1" {
-		return nil, fmt.Errorf("error order state: %s", notifyRespInfo.OrderDate)
+// getGcPaymentState 根据GC订单状态获取支付状态
+// state: GC订单状态
+// 返回支付状态，GC只约定"1"表示支付成功，其他状态返回 PaymentStateError，原始状态记录在通知消息中
+func getGcPaymentState(state string) PaymentState {
+	if state == "1" {
+		return PaymentStatePaid
 	}
-	// 构建通知结果
-	notifyResult := &NotifyResult{
-		ProductName:        productName,        // 产品名称
-		ProductDisplayName: productDisplayName, // 产品显示名称
-		ProviderName:       providerName,       // 提供者名称
-		OrderId:            orderId,            // 订单ID
-		Price:              price,              // 价格
-		PaymentStatus:      PaymentStatePaid,   // 支付状态：已支付
-		PaymentName:        paymentName,        // 支付名称
-	}
-	return notifyResult, nil
+	return PaymentStateError
+}
+
+// CloseOrder 关闭GC支付订单
+// GC网关暂未提供关闭订单接口
+// ctx: 上下文
+// orderId: 订单ID
+// 返回可能的错误
+func (pp *GcPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	return fmt.Errorf("%w: gc order close", ErrNotSupported)
 }
 
 // GetInvoice 获取GC支付的发票
+// 申请开具电子票据，已开具时返回发票URL；仍在开票中时返回包装了 ErrInvoicePending 的错误
 // 参数:
+//   - ctx: 上下文
 //   - paymentName: 支付名称（订单号）
 //   - personName: 个人姓名
 //   - personIdCard: 个人身份证号
@@ -313,7 +462,32 @@ func (pp *GcPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult,
 // 返回值:
 //   - string: 发票URL
 //   - error: 错误信息
-func (pp *GcPaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *GcPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+	invoice, err := pp.RequestInvoice(ctx, paymentName, personName, personIdCard, personEmail, personPhone, invoiceType, invoiceTitle, invoiceTaxId)
+	if err != nil {
+		return "", err
+	}
+	return invoice.getUrl()
+}
+
+// RequestInvoice 申请开具GC电子票据
+// GC异步开票，返回的发票可能仍在开票中。GC未提供只读的票据查询接口，因此不提供开具状态轮询，
+// 开票中的发票只能稍后以相同的开票信息再次申请获取结果。
+// 该方法仅在 *GcPaymentProvider 上提供，通过 Registry 创建的提供商需要类型断言后调用
+// 参数:
+//   - ctx: 上下文
+//   - paymentName: 支付名称（订单号）
+//   - personName: 个人姓名
+//   - personIdCard: 个人身份证号
+//   - personEmail: 个人邮箱
+//   - personPhone: 个人电话
+//   - invoiceType: 发票类型
+//   - invoiceTitle: 发票抬头
+//   - invoiceTaxId: 发票税号
+// 返回值:
+//   - *GcInvoice: 电子票据
+//   - error: 错误信息
+func (pp *GcPaymentProvider) RequestInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (*GcInvoice, error) {
 	// 设置支付者类型，默认为个人(0)，组织为1
 	payerType := "0"
 	if invoiceType == "Organization" {
@@ -332,75 +506,63 @@ func (pp *GcPaymentProvider) GetInvoice(paymentName string, personName string, p
 		Email:        personEmail,   // 邮箱
 	}
 
-	// 序列化发票请求信息
-	b, err := json.Marshal(invoiceReqInfo)
-	if err != nil {
-		return "", err
-	}
-
-	// 构建请求体
-	body := GcRequestBody{
-		Op:          "InvoiceEBillByOrder",                 // 操作类型：按订单开具电子票据
-		Xmpch:       pp.Xmpch,                            // 商户号
-		Version:     "1.4",                              // 版本号
-		Data:        base64.StdEncoding.EncodeToString(b), // Base64编码的数据
-		RequestTime: util.GenerateSimpleTimeId(),         // 请求时间
-	}
-
-	// 生成签名参数字符串
-	params := fmt.Sprintf("data=%s&op=%s&requesttime=%s&version=%s&xmpch=%s%s", body.Data, body.Op, body.RequestTime, body.Version, body.Xmpch, pp.SecretKey)
-	// 计算MD5签名并转为大写
-	body.Sign = strings.ToUpper(util.GetMd5Hash(params))
-
-	// 序列化请求体
-	bodyBytes, err := json.Marshal(body)
-	if err != nil {
-		return "", err
-	}
-
-	// 发送POST请求
-	respBytes, err := pp.doPost(bodyBytes)
-	if err != nil {
-		return "", err
-	}
-
-	// 解析响应体
-	var respBody GcResponseBody
-	err = json.Unmarshal(respBytes, &respBody)
-	if err != nil {
-		return "", err
-	}
-
-	// 检查返回码
-	if respBody.ReturnCode != "SUCCESS" {
-		return "", fmt.Errorf("%s: %s", respBody.ReturnCode, respBody.ReturnMsg)
-	}
-
-	// 解码响应数据
-	invoiceRespInfoBytes, err := base64.StdEncoding.DecodeString(respBody.Data)
-	if err != nil {
-		return "", err
-	}
-
-	// 解析发票响应信息
+	// 按订单开具电子票据
 	var invoiceRespInfo GcInvoiceRespInfo
-	err = json.Unmarshal(invoiceRespInfoBytes, &invoiceRespInfo)
+	err := pp.doOp(ctx, "InvoiceEBillByOrder", invoiceReqInfo, &invoiceRespInfo)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return invoiceRespInfo.toInvoice(paymentName), nil
+}
 
-	// 检查发票状态，"0"表示申请成功但正在开票中
-	if invoiceRespInfo.State == "0" {
-		return "", fmt.Errorf("申请成功，开票中")
+// toInvoice 根据GC发票响应信息构造电子票据
+// invoiceId: 发票ID
+// 返回电子票据，"0"表示申请成功但正在开票中，未返回发票URL表示开票失败
+func (info *GcInvoiceRespInfo) toInvoice(invoiceId string) *GcInvoice {
+	invoice := &GcInvoice{
+		InvoiceId: invoiceId,      // 发票ID
+		Url:       info.Url,       // 电子票据URL
+		EbillCode: info.EbillCode, // 电子票据代码
+		EbillNo:   info.EbillNo,   // 电子票据号码
+		CheckCode: info.CheckCode, // 校验码
+	}
+	switch {
+	case info.State == "0":
+		invoice.State = GcInvoiceStatePending
+	case info.Url != "":
+		invoice.State = GcInvoiceStateIssued
+	default:
+		invoice.State = GcInvoiceStateFailed
+		invoice.Message = info.Content
 	}
+	return invoice
+}
 
-	// 检查发票URL是否为空
-	if invoiceRespInfo.Url == "" {
+// getUrl 获取已开具的电子票据URL
+// 返回电子票据URL，开票中返回包装了 ErrInvoicePending 的错误，开票失败返回错误
+func (invoice *GcInvoice) getUrl() (string, error) {
+	switch invoice.State {
+	case GcInvoiceStatePending:
+		return "", fmt.Errorf("%w: gc invoice %s", ErrInvoicePending, invoice.InvoiceId)
+	case GcInvoiceStateFailed:
+		if invoice.Message != "" {
+			return "", fmt.Errorf("gc invoice %s failed: %s", invoice.InvoiceId, invoice.Message)
+		}
 		return "", fmt.Errorf("invoice URL is empty")
 	}
+	return invoice.Url, nil
+}
 
-	// 返回发票URL
-	return invoiceRespInfo.Url, nil
+// Refund 处理GC支付的退款请求
+// GC网关暂未提供退款接口，退款需在GC商户后台办理
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+// 返回值:
+//   - *RefundResp: 退款响应信息
+//   - error: 错误信息
+func (pp *GcPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	return nil, fmt.Errorf("%w: gc refund", ErrNotSupported)
 }
 
 // GetResponseError 根据错误信息返回响应状态
@@ -0,0 +1,138 @@
+// Package payment 支付相关功能
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// orderMetadataVersion 当前订单元数据编码版本
+const orderMetadataVersion = 1
+
+// legacyAttachOrder 旧版"|"分隔附加字符串的字段顺序
+// 各提供商写入旧格式时使用的顺序不同，解码时需按写入顺序还原
+type legacyAttachOrder int
+
+// 旧版附加字符串字段顺序常量定义
+const (
+	legacyAttachNameFirst    legacyAttachOrder = iota // ProductName|ProductDisplayName|ProviderName
+	legacyAttachDisplayFirst                          // ProductDisplayName|ProductName|ProviderName
+)
+
+// orderMetadata 订单元数据
+// 随订单发送给支付平台并在通知中原样返回，编码为带版本号的紧凑JSON
+type orderMetadata struct {
+	Version            int               `json:"v"`            // 编码版本
+	ProductName        string            `json:"pn,omitempty"` // 产品名称
+	ProductDisplayName string            `json:"pd,omitempty"` // 产品显示名称
+	ProviderName       string            `json:"pv,omitempty"` // 提供商名称
+	Metadata           map[string]string `json:"md,omitempty"` // 商户自定义元数据
+}
+
+// encodeOrderMetadata 编码订单元数据
+// 参数:
+//   - r: 支付请求信息
+//   - maxLen: 支付平台对该字段的最大字符数限制，不大于0时不限制
+//
+// 返回:
+//   - string: 编码后的字符串
+//   - error: 超出长度限制时返回错误
+func encodeOrderMetadata(r *PayReq, maxLen int) (string, error) {
+	m := &orderMetadata{
+		Version:            orderMetadataVersion,
+		ProductName:        r.ProductName,
+		ProductDisplayName: r.ProductDisplayName,
+		ProviderName:       r.ProviderName,
+		Metadata:           r.Metadata,
+	}
+
+	// 不转义HTML字符，避免编码结果变长
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(m); err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(buf.String(), "\n")
+
+	if maxLen > 0 && utf8.RuneCountInString(s) > maxLen {
+		return "", fmt.Errorf("order metadata exceeds %d characters: %d", maxLen, utf8.RuneCountInString(s))
+	}
+	return s, nil
+}
+
+// decodeOrderMetadata 解码订单元数据
+// 兼容旧版"|"分隔的附加字符串
+// 参数:
+//   - s: 编码后的字符串
+//   - legacyOrder: 旧版附加字符串的字段顺序
+//
+// 返回:
+//   - *orderMetadata: 订单元数据
+//   - error: 错误信息
+func decodeOrderMetadata(s string, legacyOrder legacyAttachOrder) (*orderMetadata, error) {
+	m := &orderMetadata{}
+	if s == "" {
+		return m, nil
+	}
+
+	// 旧版格式
+	if !strings.HasPrefix(s, "{") {
+		first, second, providerName, err := parseAttachString(s)
+		if err != nil {
+			return nil, err
+		}
+		m.ProviderName = providerName
+		if legacyOrder == legacyAttachDisplayFirst {
+			m.ProductDisplayName, m.ProductName = first, second
+		} else {
+			m.ProductName, m.ProductDisplayName = first, second
+		}
+		return m, nil
+	}
+
+	if err := json.Unmarshal([]byte(s), m); err != nil {
+		return nil, fmt.Errorf("invalid order metadata: %w", err)
+	}
+	if m.Version < 1 || m.Version > orderMetadataVersion {
+		return nil, fmt.Errorf("unsupported order metadata version: %d", m.Version)
+	}
+	return m, nil
+}
+
+// applyOrderMetadata 解码订单元数据并填充到通知结果
+// 元数据无法解码时不影响支付状态，仅保留空的产品信息
+// 参数:
+//   - notifyResult: 通知结果
+//   - s: 编码后的字符串
+//   - legacyOrder: 旧版附加字符串的字段顺序
+func applyOrderMetadata(notifyResult *NotifyResult, s string, legacyOrder legacyAttachOrder) {
+	m, err := decodeOrderMetadata(s, legacyOrder)
+	if err != nil {
+		return
+	}
+	notifyResult.ProductName = m.ProductName
+	notifyResult.ProductDisplayName = m.ProductDisplayName
+	notifyResult.ProviderName = m.ProviderName
+	notifyResult.Metadata = m.Metadata
+}
+
+// applyOrderMetadataToOrder 解码订单元数据并填充到订单查询结果
+// 与 applyOrderMetadata 相同，元数据无法解码时仅保留空的产品信息
+// 参数:
+//   - orderResult: 订单查询结果
+//   - s: 编码后的字符串
+//   - legacyOrder: 旧版附加字符串的字段顺序
+func applyOrderMetadataToOrder(orderResult *OrderResult, s string, legacyOrder legacyAttachOrder) {
+	m, err := decodeOrderMetadata(s, legacyOrder)
+	if err != nil {
+		return
+	}
+	orderResult.ProductName = m.ProductName
+	orderResult.ProductDisplayName = m.ProductDisplayName
+	orderResult.ProviderName = m.ProviderName
+	orderResult.Metadata = m.Metadata
+}
@@ -0,0 +1,227 @@
+package payment
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PayReq
+	}{
+		{
+			name: "product names",
+			req:  PayReq{ProductName: "product", ProductDisplayName: "产品", ProviderName: "provider"},
+		},
+		{
+			name: "separator and html in names",
+			req:  PayReq{ProductName: "a|b", ProductDisplayName: "<b>产品</b> & co", ProviderName: "p|q"},
+		},
+		{
+			name: "merchant metadata",
+			req: PayReq{
+				ProductName:  "product",
+				ProviderName: "provider",
+				Metadata:     map[string]string{"user": "alice", "plan": "pro|yearly"},
+			},
+		},
+		{
+			name: "empty",
+			req:  PayReq{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := encodeOrderMetadata(&tt.req, 0)
+			if err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+			if strings.Contains(s, `\u003c`) {
+				t.Errorf("encoded metadata escapes html: %s", s)
+			}
+
+			// 新格式与旧版字段顺序无关
+			for _, legacyOrder := range []legacyAttachOrder{legacyAttachNameFirst, legacyAttachDisplayFirst} {
+				m, err := decodeOrderMetadata(s, legacyOrder)
+				if err != nil {
+					t.Fatalf("decode error: %v", err)
+				}
+				want := &orderMetadata{
+					Version:            orderMetadataVersion,
+					ProductName:        tt.req.ProductName,
+					ProductDisplayName: tt.req.ProductDisplayName,
+					ProviderName:       tt.req.ProviderName,
+					Metadata:           tt.req.Metadata,
+				}
+				if !reflect.DeepEqual(m, want) {
+					t.Errorf("decode(%s) = %+v, want %+v", s, m, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeOrderMetadataMaxLen(t *testing.T) {
+	req := &PayReq{ProductName: strings.Repeat("产", 100)}
+	s, err := encodeOrderMetadata(req, 0)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	// 长度按字符数计算
+	n := len([]rune(s))
+	if _, err = encodeOrderMetadata(req, n); err != nil {
+		t.Errorf("encode with maxLen %d error: %v", n, err)
+	}
+	if _, err = encodeOrderMetadata(req, n-1); err == nil {
+		t.Errorf("encode with maxLen %d succeeded, want error", n-1)
+	}
+}
+
+func TestDecodeLegacyOrderMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           string
+		legacyOrder legacyAttachOrder
+		want        *orderMetadata
+		wantErr     bool
+	}{
+		{
+			name:        "name first",
+			s:           "product|产品|provider",
+			legacyOrder: legacyAttachNameFirst,
+			want:        &orderMetadata{ProductName: "product", ProductDisplayName: "产品", ProviderName: "provider"},
+		},
+		{
+			name:        "display first",
+			s:           "产品|product|provider",
+			legacyOrder: legacyAttachDisplayFirst,
+			want:        &orderMetadata{ProductName: "product", ProductDisplayName: "产品", ProviderName: "provider"},
+		},
+		{
+			name:        "name first with empty fields",
+			s:           "product||",
+			legacyOrder: legacyAttachNameFirst,
+			want:        &orderMetadata{ProductName: "product"},
+		},
+		{
+			name:        "display first with empty fields",
+			s:           "||provider",
+			legacyOrder: legacyAttachDisplayFirst,
+			want:        &orderMetadata{ProviderName: "provider"},
+		},
+		{
+			name:        "name first too few fields",
+			s:           "product|provider",
+			legacyOrder: legacyAttachNameFirst,
+			wantErr:     true,
+		},
+		{
+			name:        "display first too many fields",
+			s:           "a|b|c|d",
+			legacyOrder: legacyAttachDisplayFirst,
+			wantErr:     true,
+		},
+		{
+			name:        "empty",
+			s:           "",
+			legacyOrder: legacyAttachNameFirst,
+			want:        &orderMetadata{},
+		},
+		{
+			name:        "invalid json",
+			s:           `{"v":1`,
+			legacyOrder: legacyAttachNameFirst,
+			wantErr:     true,
+		},
+		{
+			name:        "missing version",
+			s:           `{"pn":"product"}`,
+			legacyOrder: legacyAttachNameFirst,
+			wantErr:     true,
+		},
+		{
+			name:        "future version",
+			s:           `{"v":2,"pn":"product"}`,
+			legacyOrder: legacyAttachDisplayFirst,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := decodeOrderMetadata(tt.s, tt.legacyOrder)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decode(%q) = %+v, want error", tt.s, m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode(%q) error: %v", tt.s, err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("decode(%q) = %+v, want %+v", tt.s, m, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrderMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           string
+		legacyOrder legacyAttachOrder
+		want        NotifyResult
+	}{
+		{
+			name:        "current version",
+			s:           `{"v":1,"pn":"product","pd":"产品","pv":"provider","md":{"user":"alice"}}`,
+			legacyOrder: legacyAttachDisplayFirst,
+			want: NotifyResult{
+				ProductName:        "product",
+				ProductDisplayName: "产品",
+				ProviderName:       "provider",
+				Metadata:           map[string]string{"user": "alice"},
+			},
+		},
+		{
+			name:        "legacy name first",
+			s:           "product|产品|provider",
+			legacyOrder: legacyAttachNameFirst,
+			want:        NotifyResult{ProductName: "product", ProductDisplayName: "产品", ProviderName: "provider"},
+		},
+		{
+			name:        "legacy display first",
+			s:           "产品|product|provider",
+			legacyOrder: legacyAttachDisplayFirst,
+			want:        NotifyResult{ProductName: "product", ProductDisplayName: "产品", ProviderName: "provider"},
+		},
+		{
+			// 无法解码时不填充产品信息
+			name:        "undecodable",
+			s:           "free text description",
+			legacyOrder: legacyAttachNameFirst,
+			want:        NotifyResult{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifyResult := &NotifyResult{}
+			applyOrderMetadata(notifyResult, tt.s, tt.legacyOrder)
+			if !reflect.DeepEqual(*notifyResult, tt.want) {
+				t.Errorf("notify result = %+v, want %+v", *notifyResult, tt.want)
+			}
+
+			orderResult := &OrderResult{}
+			applyOrderMetadataToOrder(orderResult, tt.s, tt.legacyOrder)
+			if orderResult.ProductName != tt.want.ProductName ||
+				orderResult.ProductDisplayName != tt.want.ProductDisplayName ||
+				orderResult.ProviderName != tt.want.ProviderName ||
+				!reflect.DeepEqual(orderResult.Metadata, tt.want.Metadata) {
+				t.Errorf("order result = %+v, want product info of %+v", *orderResult, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package payment 支付相关功能
+package payment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money 金额类型
+// 以最小货币单位（如分）的整数存储金额，并携带 ISO-4217 货币代码，避免浮点数误差
+type Money struct {
+	Amount   int64  // 最小货币单位金额，例如 CNY 的分、JPY 的円
+	Currency string // ISO-4217 货币代码，例如 "CNY"、"USD"
+}
+
+// defaultCurrencyExponent 默认货币小数位数
+const defaultCurrencyExponent = 2
+
+// currencyExponents 非两位小数货币的小数位数表
+// 未列出的货币均按两位小数处理
+var currencyExponents = map[string]int{
+	// 无小数货币
+	"BIF": 0, // 布隆迪法郎
+	"CLP": 0, // 智利比索
+	"DJF": 0, // 吉布提法郎
+	"GNF": 0, // 几内亚法郎
+	"ISK": 0, // 冰岛克朗
+	"JPY": 0, // 日元
+	"KMF": 0, // 科摩罗法郎
+	"KRW": 0, // 韩元
+	"PYG": 0, // 巴拉圭瓜拉尼
+	"RWF": 0, // 卢旺达法郎
+	"UGX": 0, // 乌干达先令
+	"UYI": 0, // 乌拉圭比索（指数单位）
+	"VND": 0, // 越南盾
+	"VUV": 0, // 瓦努阿图瓦图
+	"XAF": 0, // 中非法郎
+	"XOF": 0, // 西非法郎
+	"XPF": 0, // 太平洋法郎
+
+	// 三位小数货币
+	"BHD": 3, // 巴林第纳尔
+	"IQD": 3, // 伊拉克第纳尔
+	"JOD": 3, // 约旦第纳尔
+	"KWD": 3, // 科威特第纳尔
+	"LYD": 3, // 利比亚第纳尔
+	"OMR": 3, // 阿曼里亚尔
+	"TND": 3, // 突尼斯第纳尔
+}
+
+// CurrencyExponent 获取货币的小数位数
+// 参数:
+//   - currency: ISO-4217 货币代码，不区分大小写
+//
+// 返回:
+//   - int: 小数位数
+func CurrencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return defaultCurrencyExponent
+}
+
+// NewMoney 创建金额
+// 参数:
+//   - amount: 最小货币单位金额
+//   - currency: ISO-4217 货币代码
+//
+// 返回:
+//   - Money: 金额
+func NewMoney(amount int64, currency string) Money {
+	return Money{
+		Amount:   amount,
+		Currency: strings.ToUpper(currency),
+	}
+}
+
+// ParseMoney 将主货币单位的金额字符串解析为金额
+// 例如 ParseMoney("12.34", "USD") 得到 1234 美分，ParseMoney("1000", "JPY") 得到 1000 円
+// 参数:
+//   - value: 主货币单位的金额字符串
+//   - currency: ISO-4217 货币代码
+//
+// 返回:
+//   - Money: 金额
+//   - error: 格式错误或精度超出货币小数位数时返回错误
+func ParseMoney(value string, currency string) (Money, error) {
+	exponent := CurrencyExponent(currency)
+	s := strings.TrimSpace(value)
+
+	// 处理符号
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	// 拆分整数部分和小数部分
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return Money{}, fmt.Errorf("invalid money amount: %q", value)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, fmt.Errorf("invalid money amount: %q", value)
+	}
+
+	// 超出货币小数位数的部分只允许为0
+	if len(fracPart) > exponent {
+		if strings.Trim(fracPart[exponent:], "0") != "" {
+			return Money{}, fmt.Errorf("money amount %q exceeds %d decimal places of %s", value, exponent, currency)
+		}
+		fracPart = fracPart[:exponent]
+	}
+	fracPart += strings.Repeat("0", exponent-len(fracPart))
+
+	amount, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid money amount: %q: %w", value, err)
+	}
+	if negative {
+		amount = -amount
+	}
+	return NewMoney(amount, currency), nil
+}
+
+// isDigits 判断字符串是否只包含数字
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsZero 判断金额是否为0
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String 将金额格式化为主货币单位字符串
+// 小数位数由货币决定，例如 "12.34"（USD）、"1000"（JPY）、"1.500"（KWD）
+func (m Money) String() string {
+	exponent := CurrencyExponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exponent == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+
+	pow := int64(1)
+	for i := 0; i < exponent; i++ {
+		pow *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/pow, exponent, amount%pow)
+}
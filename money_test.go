@@ -0,0 +1,118 @@
+package payment
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{name: "two decimals", value: "12.34", currency: "USD", want: NewMoney(1234, "USD")},
+		{name: "two decimals without fraction", value: "12", currency: "CNY", want: NewMoney(1200, "CNY")},
+		{name: "lower case currency", value: "0.5", currency: "usd", want: NewMoney(50, "USD")},
+		{name: "leading dot", value: ".05", currency: "USD", want: NewMoney(5, "USD")},
+		{name: "trailing zeros", value: "1.2300", currency: "USD", want: NewMoney(123, "USD")},
+		{name: "JPY", value: "1000", currency: "JPY", want: NewMoney(1000, "JPY")},
+		{name: "JPY zero fraction", value: "1000.00", currency: "JPY", want: NewMoney(1000, "JPY")},
+		{name: "JPY fraction", value: "1000.5", currency: "JPY", wantErr: true},
+		{name: "KRW", value: "15000", currency: "KRW", want: NewMoney(15000, "KRW")},
+		{name: "KRW fraction", value: "15000.1", currency: "KRW", wantErr: true},
+		{name: "BHD", value: "1.234", currency: "BHD", want: NewMoney(1234, "BHD")},
+		{name: "BHD short fraction", value: "1.5", currency: "BHD", want: NewMoney(1500, "BHD")},
+		{name: "KWD", value: "0.005", currency: "KWD", want: NewMoney(5, "KWD")},
+		{name: "KWD too many fraction digits", value: "1.2345", currency: "KWD", wantErr: true},
+		{name: "USD too many fraction digits", value: "12.345", currency: "USD", wantErr: true},
+		{name: "negative", value: "-12.34", currency: "USD", want: NewMoney(-1234, "USD")},
+		{name: "negative JPY", value: "-500", currency: "JPY", want: NewMoney(-500, "JPY")},
+		{name: "plus sign", value: "+1.00", currency: "USD", want: NewMoney(100, "USD")},
+		{name: "surrounding spaces", value: " 1.00 ", currency: "USD", want: NewMoney(100, "USD")},
+		{name: "unknown currency uses two decimals", value: "12.34", currency: "XYZ", want: NewMoney(1234, "XYZ")},
+		{name: "unknown currency too many fraction digits", value: "12.345", currency: "XYZ", wantErr: true},
+		{name: "empty", value: "", currency: "USD", wantErr: true},
+		{name: "only dot", value: ".", currency: "USD", wantErr: true},
+		{name: "only sign", value: "-", currency: "USD", wantErr: true},
+		{name: "double sign", value: "--1", currency: "USD", wantErr: true},
+		{name: "letters", value: "1a.00", currency: "USD", wantErr: true},
+		{name: "two dots", value: "1.2.3", currency: "USD", wantErr: true},
+		{name: "thousands separator", value: "1,000.00", currency: "USD", wantErr: true},
+		{name: "overflow", value: "92233720368547758.08", currency: "USD", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.value, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseMoney(%q, %q) = %v, want error", tt.value, tt.currency, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q, %q) error: %v", tt.value, tt.currency, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q, %q) = %#v, want %#v", tt.value, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		name  string
+		money Money
+		want  string
+	}{
+		{name: "two decimals", money: NewMoney(1234, "USD"), want: "12.34"},
+		{name: "two decimals padding", money: NewMoney(5, "CNY"), want: "0.05"},
+		{name: "zero", money: NewMoney(0, "USD"), want: "0.00"},
+		{name: "JPY", money: NewMoney(1000, "JPY"), want: "1000"},
+		{name: "KRW", money: NewMoney(15000, "KRW"), want: "15000"},
+		{name: "BHD", money: NewMoney(1234, "BHD"), want: "1.234"},
+		{name: "KWD", money: NewMoney(1500, "KWD"), want: "1.500"},
+		{name: "KWD padding", money: NewMoney(5, "KWD"), want: "0.005"},
+		{name: "negative", money: NewMoney(-1234, "USD"), want: "-12.34"},
+		{name: "negative below one", money: NewMoney(-5, "USD"), want: "-0.05"},
+		{name: "negative JPY", money: NewMoney(-500, "JPY"), want: "-500"},
+		{name: "unknown currency uses two decimals", money: NewMoney(1234, "XYZ"), want: "12.34"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("%#v.String() = %q, want %q", tt.money, got, tt.want)
+			}
+
+			// 格式化结果可以解析回原金额
+			parsed, err := ParseMoney(tt.want, tt.money.Currency)
+			if err != nil {
+				t.Fatalf("ParseMoney(%q) error: %v", tt.want, err)
+			}
+			if parsed != tt.money {
+				t.Errorf("ParseMoney(%q) = %#v, want %#v", tt.want, parsed, tt.money)
+			}
+		})
+	}
+}
+
+func TestCurrencyExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+	}{
+		{currency: "USD", want: 2},
+		{currency: "cny", want: 2},
+		{currency: "JPY", want: 0},
+		{currency: "krw", want: 0},
+		{currency: "BHD", want: 3},
+		{currency: "KWD", want: 3},
+		{currency: "XYZ", want: defaultCurrencyExponent},
+		{currency: "", want: defaultCurrencyExponent},
+	}
+	for _, tt := range tests {
+		if got := CurrencyExponent(tt.currency); got != tt.want {
+			t.Errorf("CurrencyExponent(%q) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
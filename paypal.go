@@ -2,52 +2,113 @@
 package payment
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-pay/gopay"
 	"github.com/go-pay/gopay/paypal"
 )
 
+// PayPal REST接口地址
+const (
+	paypalBaseUrlProd    = "https://api-m.paypal.com"         // 正式环境
+	paypalBaseUrlSandbox = "https://api-m.sandbox.paypal.com" // 沙箱环境
+)
+
+// PayPal访问令牌缓存时间，令牌有效期由 expires_in 给出，通常为9小时
+const (
+	paypalTokenRefreshMargin = 5 * time.Minute  // 令牌过期前提前刷新的时间
+	paypalTokenFallbackTTL   = 10 * time.Minute // 响应未给出有效期时的缓存时间
+)
+
 // PaypalPaymentProvider PayPal支付提供者结构体
 type PaypalPaymentProvider struct {
-	Client *paypal.Client // PayPal客户端实例
+	Client      *paypal.Client    // PayPal客户端实例，提供客户端ID和密钥
+	APIEndpoint string            // REST接口地址，默认根据环境设置，测试时可指向本地替身服务
+	WebhookId   string            // Webhook ID，用于通知验签
+	Experience  ExperienceContext // 默认收银台体验配置，可被支付请求覆盖
+
+	tokenMutex  sync.Mutex // 令牌锁
+	accessToken string     // 缓存的访问令牌
+	tokenExpiry time.Time  // 缓存的访问令牌需要刷新的时间
 }
 
 // NewPaypalPaymentProvider 创建新的PayPal支付提供者实例
 // clientID: PayPal应用的客户端ID
 // secret: PayPal应用的密钥
-// 返回PayPal支付提供者实例和可能的错误
-func NewPaypalPaymentProvider(clientID string, secret string) (*PaypalPaymentProvider, error) {
-	pp := &PaypalPaymentProvider{}
-	// 创建PayPal客户端，第三个参数true表示使用沙箱环境
-	client, err := paypal.NewClient(clientID, secret, true)
+// webhookId: PayPal开发者后台中配置的Webhook ID
+// env: 网关环境，沙箱环境使用 api-m.sandbox.paypal.com
+// 返回PayPal支付提供者实例和可能的错误，缺少必填参数时错误包装 ErrProviderNotConfigured
+func NewPaypalPaymentProvider(clientID string, secret string, webhookId string, env Environment) (*PaypalPaymentProvider, error) {
+	// 检查必要参数
+	err := checkRequiredParams(ProviderTypePaypal,
+		requiredParam{"clientID", clientID},
+		requiredParam{"secret", secret},
+		requiredParam{"webhookId", webhookId},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = env.Validate(); err != nil {
+		return nil, err
+	}
+	// 根据环境设置接口地址
+	apiEndpoint := paypalBaseUrlProd
+	if !env.IsProd() {
+		apiEndpoint = paypalBaseUrlSandbox
+	}
+	pp := &PaypalPaymentProvider{
+		APIEndpoint: apiEndpoint,
+		WebhookId:   webhookId,
+	}
+	// 创建PayPal客户端，第三个参数表示是否为正式环境
+	client, err := paypal.NewClient(clientID, secret, env.IsProd())
 	if err != nil {
 		return nil, err
 	}
 
 	pp.Client = client
+	// 缓存创建客户端时获取的访问令牌，之后由 getAccessToken 刷新
+	pp.accessToken = client.AccessToken
+	pp.tokenExpiry = getPaypalTokenExpiry(client.ExpiresIn, time.Now())
 	return pp, nil
 }
 
 // Pay 处理PayPal支付请求
+// ctx: 上下文
 // r: 支付请求参数
 // 返回支付响应和可能的错误
-func (pp *PaypalPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *PaypalPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	if err := checkRecurringNotSupported(ProviderTypePaypal, r); err != nil {
+		return nil, err
+	}
+
 	// 参考文档: https://github.com/go-pay/gopay/blob/main/doc/paypal.md
+	// 编码订单元数据，custom_id 最长127个字符
+	customId, err := encodeOrderMetadata(r, 127)
+	if err != nil {
+		return nil, err
+	}
 	// 创建购买单元数组
 	units := make([]*paypal.PurchaseUnit, 0, 1)
 	// 构建购买单元
 	unit := &paypal.PurchaseUnit{
 		ReferenceId: GetRandomString(16), // 生成随机引用ID
 		Amount: &paypal.Amount{
-			CurrencyCode: r.Currency,                    // 货币代码，例如"USD"
-			Value:        priceFloat64ToString(r.Price), // 价格字符串，例如"100.00"
+			CurrencyCode: r.Price.Currency, // 货币代码，例如"USD"
+			Value:        r.Price.String(), // 价格字符串，例如"100.00"
 		},
-		// 将产品信息组合为描述
-		Description: joinAttachString([]string{r.ProductDisplayName, r.ProductName, r.ProviderName}),
+		Description: r.ProductDisplayName, // 产品显示名称，会展示给买家
+		CustomId:    customId,             // 订单元数据，不展示给买家
 	}
 	units = append(units, unit)
 
@@ -55,22 +116,30 @@ func (pp *PaypalPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 	bm := make(gopay.BodyMap)
 	bm.Set("intent", "CAPTURE")     // 设置支付意图为捕获
 	bm.Set("purchase_units", units) // 设置购买单元
-	// 设置应用上下文
-	bm.SetBodyMap("application_context", func(b gopay.BodyMap) {
-		b.Set("brand_name", "Casdoor")   // 品牌名称
-		b.Set("locale", "en-PT")         // 语言环境
-		b.Set("return_url", r.ReturnUrl) // 支付成功返回URL
-		b.Set("cancel_url", r.ReturnUrl) // 支付取消返回URL
+	// 设置买家支付体验，application_context 已被PayPal弃用
+	experience := pp.Experience.merge(r.Experience)
+	bm.SetBodyMap("payment_source", func(b gopay.BodyMap) {
+		b.SetBodyMap("paypal", func(b gopay.BodyMap) {
+			b.Set("experience_context", &paypalExperienceContext{
+				BrandName:          experience.BrandName,
+				Locale:             experience.Locale,
+				LandingPage:        experience.LandingPage,
+				ShippingPreference: experience.ShippingPreference,
+				UserAction:         experience.UserAction,
+				ReturnUrl:          r.ReturnUrl,    // 支付成功返回URL
+				CancelUrl:          r.getFailUrl(), // 支付取消返回URL
+			})
+		})
 	})
 
 	// 创建PayPal订单
-	ppRsp, err := pp.Client.CreateOrder(context.Background(), bm)
+	order := &paypalOrder{}
+	err = pp.doRequest(ctx, http.MethodPost, "/v2/checkout/orders", "", bm, order)
 	if err != nil {
 		return nil, err
 	}
-	// 检查响应状态
-	if ppRsp.Code != paypal.Success {
-		return nil, errors.New(ppRsp.Error)
+	if order.Id == "" {
+		return nil, newError(ProviderTypePaypal, ErrorCodeProviderUnavailable, "", "paypal create order response has no order id")
 	}
 	// PayPal响应示例:
 	// {"id":"9BR68863NE220374S","status":"CREATED",
@@ -78,105 +147,145 @@ func (pp *PaypalPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 	// 			{"href":"https://www.sandbox.paypal.com/checkoutnow?token=9BR68863NE220374S","rel":"approve","method":"GET"},
 	// 			{"href":"https://api.sandbox.paypal.com/v2/checkout/orders/9BR68863NE220374S","rel":"update","method":"PATCH"},
 	// 			{"href":"https://api.sandbox.paypal.com/v2/checkout/orders/9BR68863NE220374S/capture","rel":"capture","method":"POST"}]}
+	// 链接顺序不固定，按 rel 查找买家批准链接
+	payUrl := getPaypalLink(order.Links, "approve", "payer-action")
+	if payUrl == "" {
+		return nil, newError(ProviderTypePaypal, ErrorCodeProviderUnavailable, "", fmt.Sprintf("paypal order %s has no approve link", order.Id))
+	}
+
 	// 构建支付响应
 	payResp := &PayResp{
-		PayUrl:  ppRsp.Response.Links[1].Href, // 获取支付链接（approve链接）
-		OrderId: ppRsp.Response.Id,            // 订单ID
+		PayUrl:  payUrl,   // 支付链接（approve或payer-action链接）
+		OrderId: order.Id, // 订单ID
 	}
 	return payResp, nil
 }
 
 // Notify 处理PayPal支付回调通知
+// 先通过PayPal接口校验Webhook签名，再根据事件中的资源返回通知结果
+// 订单批准事件（CHECKOUT.ORDER.APPROVED）需要捕获订单支付，其他事件只解析事件内容
+// ctx: 上下文
+// header: 回调请求头，包含 PAYPAL-TRANSMISSION-* 签名信息
 // body: 回调请求体
 // orderId: 订单ID
 // 返回通知结果和可能的错误
-func (pp *PaypalPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	notifyResult := &NotifyResult{}
-	// 尝试捕获订单支付
-	captureRsp, err := pp.Client.OrderCapture(context.Background(), orderId, nil)
+func (pp *PaypalPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	// 校验签名并解析事件
+	event, err := pp.verifyWebhookEvent(ctx, header, body)
 	if err != nil {
 		return nil, err
 	}
-	// 检查捕获响应状态
-	if captureRsp.Code != paypal.Success {
-		errDetail := captureRsp.ErrorResponse.Details[0]
-		switch errDetail.Issue {
-		// 如果订单已经被捕获，跳过此类错误并检查订单详情
-		case "ORDER_ALREADY_CAPTURED":
-			// 跳过处理
-		case "ORDER_NOT_APPROVED":
-			// 订单未被批准，设置为取消状态
-			notifyResult.PaymentStatus = PaymentStateCanceled
-			notifyResult.NotifyMessage = errDetail.Description
-			return notifyResult, nil
-		default:
-			// 其他错误
-			err = fmt.Errorf(errDetail.Description)
+
+	switch {
+	case strings.HasPrefix(event.EventType, "CHECKOUT.ORDER."):
+		// 订单事件的资源为订单本身
+		order := &paypalOrder{}
+		if err = json.Unmarshal(event.Resource, order); err != nil {
+			return nil, err
+		}
+		orderId, err = getNotifyOrderId(orderId, order.Id)
+		if err != nil {
 			return nil, err
 		}
+		if event.EventType == "CHECKOUT.ORDER.APPROVED" {
+			return pp.captureOrder(ctx, orderId)
+		}
+		orderResult, err := order.toOrderResult()
+		if err != nil {
+			return nil, err
+		}
+		return orderResult.toNotifyResult(), nil
+	case strings.HasPrefix(event.EventType, "PAYMENT.CAPTURE."):
+		// 支付捕获事件的资源为捕获记录，其中包含关联的订单ID
+		capture := &paypalCapture{}
+		if err = json.Unmarshal(event.Resource, capture); err != nil {
+			return nil, err
+		}
+		orderId, err = getNotifyOrderId(orderId, capture.SupplementaryData.RelatedIds.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		return capture.toNotifyResult(orderId)
+	default:
+		return nil, fmt.Errorf("unsupported paypal event type: %s", event.EventType)
 	}
-	// 检查订单详情
-	detailRsp, err := pp.Client.OrderDetail(context.Background(), orderId, nil)
+}
+
+// captureOrder 捕获已批准订单的支付并返回通知结果
+// 使用订单ID作为 PayPal-Request-Id，重复通知不会重复捕获
+// ctx: 上下文
+// orderId: 订单ID
+// 返回通知结果和可能的错误
+func (pp *PaypalPaymentProvider) captureOrder(ctx context.Context, orderId string) (*NotifyResult, error) {
+	order := &paypalOrder{}
+	err := pp.doRequest(ctx, http.MethodPost, fmt.Sprintf("/v2/checkout/orders/%s/capture", orderId), orderId, nil, order)
 	if err != nil {
-		return nil, err
-	}
-	// 检查订单详情响应状态
-	if detailRsp.Code != paypal.Success {
-		errDetail := detailRsp.ErrorResponse.Details[0]
-		switch errDetail.Issue {
+		var payErr *Error
+		if !errors.As(err, &payErr) {
+			return nil, err
+		}
+		switch payErr.VendorCode {
+		case "ORDER_ALREADY_CAPTURED":
+			// 订单已经被捕获，查询订单详情
+			orderResult, err := pp.QueryOrder(ctx, orderId)
+			if err != nil {
+				return nil, err
+			}
+			return orderResult.toNotifyResult(), nil
 		case "ORDER_NOT_APPROVED":
 			// 订单未被批准，设置为取消状态
-			notifyResult.PaymentStatus = PaymentStateCanceled
-			notifyResult.NotifyMessage = errDetail.Description
+			notifyResult := &NotifyResult{
+				PaymentName:   orderId,
+				PaymentStatus: PaymentStateCanceled,
+				NotifyMessage: payErr.VendorMessage,
+				OrderId:       orderId,
+			}
 			return notifyResult, nil
 		default:
-			// 其他错误
-			err = fmt.Errorf(errDetail.Description)
 			return nil, err
 		}
 	}
 
-	// 解析订单详情
-	paymentName := detailRsp.Response.Id
-	// 解析价格
-	price, err := strconv.ParseFloat(detailRsp.Response.PurchaseUnits[0].Amount.Value, 64)
+	orderResult, err := order.toOrderResult()
 	if err != nil {
 		return nil, err
 	}
-	// 获取货币代码
-	currency := detailRsp.Response.PurchaseUnits[0].Amount.CurrencyCode
-	// 解析产品信息
-	productDisplayName, productName, providerName, err := parseAttachString(detailRsp.Response.PurchaseUnits[0].Description)
+	return orderResult.toNotifyResult(), nil
+}
+
+// QueryOrder 查询PayPal订单
+// 只查询订单详情，不会捕获订单支付
+// ctx: 上下文
+// orderId: 订单ID
+// 返回订单查询结果和可能的错误
+func (pp *PaypalPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	order := &paypalOrder{}
+	err := pp.doRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/checkout/orders/%s", orderId), "", nil, order)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: 更好的状态处理，例如处理挂起状态
-	// 根据订单状态设置支付状态
-	var paymentStatus PaymentState
-	switch detailRsp.Response.Status { // 可能的状态：CREATED、SAVED、APPROVED、VOIDED、COMPLETED、PAYER_ACTION_REQUIRED
-	case "COMPLETED":
-		// 订单已完成
-		paymentStatus = PaymentStatePaid
-	default:
-		// 其他状态视为错误
-		paymentStatus = PaymentStateError
-	}
-	// 构建通知结果
-	notifyResult = &NotifyResult{
-		PaymentStatus:      paymentStatus,      // 支付状态
-		PaymentName:        paymentName,        // 支付名称
-		ProductName:        productName,        // 产品名称
-		ProductDisplayName: productDisplayName, // 产品显示名称
-		ProviderName:       providerName,       // 提供者名称
-		Price:              price,              // 价格
-		Currency:           currency,           // 货币
+	return order.toOrderResult()
+}
 
-		OrderId: orderId, // 订单ID
+// CloseOrder 关闭PayPal订单
+// PayPal订单接口不支持作废 CAPTURE 类型的订单，未支付的订单只能等待PayPal自动过期
+// ctx: 上下文
+// orderId: 订单ID
+// 返回可能的错误，订单已支付时返回支付错误，否则返回 ErrNotSupported
+func (pp *PaypalPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 检查订单状态
+	orderResult, err := pp.QueryOrder(ctx, orderId)
+	if err != nil {
+		return err
 	}
-	return notifyResult, nil
+	if ok, err := checkOrderClosable(ProviderTypePaypal, orderResult); !ok {
+		return err
+	}
+	return fmt.Errorf("%w: paypal orders cannot be voided, order %s will expire automatically", ErrNotSupported, orderId)
 }
 
 // GetInvoice 获取发票信息
+// ctx: 上下文
 // paymentName: 支付名称
 // personName: 个人姓名
 // personIdCard: 身份证号
@@ -186,11 +295,511 @@ func (pp *PaypalPaymentProvider) Notify(body []byte, orderId string) (*NotifyRes
 // invoiceTitle: 发票抬头
 // invoiceTaxId: 税号
 // 返回发票URL和可能的错误
-func (pp *PaypalPaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *PaypalPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
 	// PayPal暂不支持发票功能
 	return "", nil
 }
 
+// paypalExperienceContext PayPal买家支付体验结构体
+// 对应 payment_source.paypal.experience_context，空字段不发送
+type paypalExperienceContext struct {
+	BrandName          string `json:"brand_name,omitempty"`          // 品牌名称
+	Locale             string `json:"locale,omitempty"`              // 语言环境
+	LandingPage        string `json:"landing_page,omitempty"`        // 着陆页
+	ShippingPreference string `json:"shipping_preference,omitempty"` // 收货地址
+	UserAction         string `json:"user_action,omitempty"`         // 买家操作
+	ReturnUrl          string `json:"return_url,omitempty"`          // 支付成功返回URL
+	CancelUrl          string `json:"cancel_url,omitempty"`          // 支付取消返回URL
+}
+
+// paypalWebhookEvent PayPal Webhook事件结构体
+type paypalWebhookEvent struct {
+	Id        string          `json:"id"`         // 事件ID
+	EventType string          `json:"event_type"` // 事件类型，例如 CHECKOUT.ORDER.APPROVED、PAYMENT.CAPTURE.COMPLETED
+	Resource  json.RawMessage `json:"resource"`   // 事件资源，订单事件为订单，支付捕获事件为捕获记录
+}
+
+// verifyWebhookEvent 校验PayPal Webhook签名并解析事件
+// 参考文档: https://developer.paypal.com/docs/api/webhooks/v1/#verify-webhook-signature
+// ctx: 上下文
+// header: 回调请求头
+// body: 回调请求体
+// 返回解析后的事件和可能的错误
+func (pp *PaypalPaymentProvider) verifyWebhookEvent(ctx context.Context, header http.Header, body []byte) (*paypalWebhookEvent, error) {
+	event := &paypalWebhookEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, err
+	}
+
+	// 请求体原文作为 webhook_event 提交，避免重新序列化破坏签名
+	bm := make(gopay.BodyMap)
+	bm.Set("auth_algo", header.Get("PAYPAL-AUTH-ALGO"))
+	bm.Set("cert_url", header.Get("PAYPAL-CERT-URL"))
+	bm.Set("transmission_id", header.Get("PAYPAL-TRANSMISSION-ID"))
+	bm.Set("transmission_sig", header.Get("PAYPAL-TRANSMISSION-SIG"))
+	bm.Set("transmission_time", header.Get("PAYPAL-TRANSMISSION-TIME"))
+	bm.Set("webhook_id", pp.WebhookId)
+	bm.Set("webhook_event", json.RawMessage(body))
+
+	var verifyRsp struct {
+		VerificationStatus string `json:"verification_status"` // 校验结果：SUCCESS、FAILURE
+	}
+	err := pp.doRequest(ctx, http.MethodPost, "/v1/notifications/verify-webhook-signature", "", bm, &verifyRsp)
+	if err != nil {
+		return nil, err
+	}
+	if verifyRsp.VerificationStatus != "SUCCESS" {
+		return nil, fmt.Errorf("%w: paypal: verification status %s", ErrInvalidNotifySignature, verifyRsp.VerificationStatus)
+	}
+	return event, nil
+}
+
+// paypalCapture PayPal支付捕获信息结构体
+// gopay 的 Capture 结构体缺少 id 字段，退款时需要自行解析
+type paypalCapture struct {
+	Id                string         `json:"id"`          // 捕获ID
+	Status            string         `json:"status"`      // 捕获状态：COMPLETED、PENDING、DECLINED、PARTIALLY_REFUNDED、REFUNDED、FAILED
+	Amount            *paypal.Amount `json:"amount"`      // 捕获金额
+	CustomId          string         `json:"custom_id"`   // 订单元数据，即购买单元的 custom_id
+	CreateTime        string         `json:"create_time"` // 捕获时间
+	SupplementaryData struct {
+		RelatedIds struct {
+			OrderId string `json:"order_id"` // 关联的订单ID
+		} `json:"related_ids"`
+	} `json:"supplementary_data"` // 补充信息
+}
+
+// toNotifyResult 根据支付捕获事件中的捕获记录构造通知结果
+// orderId: 订单ID
+// 返回通知结果和可能的错误
+func (c *paypalCapture) toNotifyResult(orderId string) (*NotifyResult, error) {
+	notifyResult := &NotifyResult{
+		PaymentName: orderId, // 支付名称
+		OrderId:     orderId, // 订单ID
+	}
+	notifyResult.PaymentStatus = getPaypalCaptureState(c.Status)
+	if notifyResult.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected paypal capture status: %v", c.Status)
+		return notifyResult, nil
+	}
+	if notifyResult.PaymentStatus != PaymentStatePaid {
+		return notifyResult, nil
+	}
+	if c.Amount == nil {
+		return nil, fmt.Errorf("paypal capture %s has no amount", c.Id)
+	}
+	price, err := ParseMoney(c.Amount.Value, c.Amount.CurrencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	notifyResult = &NotifyResult{
+		PaymentStatus: PaymentStatePaid, // 支付状态
+		PaymentName:   orderId,          // 支付名称
+		Price:         price,            // 价格
+
+		OrderId: orderId, // 订单ID
+	}
+	// 捕获记录中只有 custom_id，旧订单的产品信息无法从中获取
+	applyOrderMetadata(notifyResult, c.CustomId, legacyAttachDisplayFirst)
+	return notifyResult, nil
+}
+
+// paypalOrder PayPal订单详情结构体
+// gopay 的 OrderDetail 缺少付款人姓名和捕获ID等字段，需要自行解析
+type paypalOrder struct {
+	Id         string `json:"id"`          // 订单ID
+	Status     string `json:"status"`      // 订单状态：CREATED、SAVED、APPROVED、VOIDED、COMPLETED、PAYER_ACTION_REQUIRED
+	CreateTime string `json:"create_time"` // 创建时间
+	Payer      *struct {
+		PayerId      string `json:"payer_id"`      // 付款人ID
+		EmailAddress string `json:"email_address"` // 付款人邮箱
+		Name         struct {
+			GivenName string `json:"given_name"` // 名
+			Surname   string `json:"surname"`    // 姓
+		} `json:"name"`
+	} `json:"payer"` // 付款人信息，买家批准订单后返回
+	PurchaseUnits []struct {
+		Amount      *paypal.Amount `json:"amount"`      // 订单金额
+		Description string         `json:"description"` // 产品显示名称，旧订单为附加字符串
+		CustomId    string         `json:"custom_id"`   // 订单元数据
+		Payments    struct {
+			Captures []*paypalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"` // 购买单元
+	Links []*paypal.Link `json:"links"` // 相关链接，创建订单时包含买家批准链接
+}
+
+// toOrderResult 根据订单详情构造订单查询结果
+// 返回订单查询结果和可能的错误
+func (o *paypalOrder) toOrderResult() (*OrderResult, error) {
+	if len(o.PurchaseUnits) == 0 {
+		return nil, fmt.Errorf("paypal order %s has no purchase units", o.Id)
+	}
+	unit := o.PurchaseUnits[0]
+
+	orderResult := &OrderResult{
+		OrderId:       o.Id,
+		PaymentName:   o.Id,
+		PaymentStatus: getPaypalOrderState(o.Status),
+		VendorState:   o.Status,
+	}
+	if unit.Amount != nil {
+		price, err := ParseMoney(unit.Amount.Value, unit.Amount.CurrencyCode)
+		if err != nil {
+			return nil, err
+		}
+		orderResult.Price = price
+	}
+	if o.CreateTime != "" {
+		createTime, err := time.Parse(time.RFC3339, o.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+		orderResult.CreateTime = createTime
+	}
+	if o.Payer != nil {
+		orderResult.PayerId = o.Payer.PayerId
+		orderResult.PayerEmail = o.Payer.EmailAddress
+		orderResult.PayerName = strings.TrimSpace(o.Payer.Name.GivenName + " " + o.Payer.Name.Surname)
+	}
+
+	// 订单完成后以捕获状态为准，捕获可能仍在处理中或被拒绝
+	if len(unit.Payments.Captures) > 0 && unit.Payments.Captures[0] != nil {
+		capture := unit.Payments.Captures[0]
+		orderResult.TransactionId = capture.Id
+		if orderResult.PaymentStatus == PaymentStatePaid {
+			orderResult.PaymentStatus = getPaypalCaptureState(capture.Status)
+			orderResult.VendorState = fmt.Sprintf("%s/%s", o.Status, capture.Status)
+		}
+		if orderResult.PaymentStatus == PaymentStatePaid && capture.Amount != nil {
+			paidPrice, err := ParseMoney(capture.Amount.Value, capture.Amount.CurrencyCode)
+			if err != nil {
+				return nil, err
+			}
+			orderResult.PaidPrice = paidPrice
+		}
+		if orderResult.PaymentStatus == PaymentStatePaid && capture.CreateTime != "" {
+			payTime, err := time.Parse(time.RFC3339, capture.CreateTime)
+			if err != nil {
+				return nil, err
+			}
+			orderResult.PayTime = payTime
+		}
+	}
+
+	// 解析订单元数据，旧订单的产品信息保存在描述中
+	if unit.CustomId != "" {
+		applyOrderMetadataToOrder(orderResult, unit.CustomId, legacyAttachDisplayFirst)
+	} else {
+		applyOrderMetadataToOrder(orderResult, unit.Description, legacyAttachDisplayFirst)
+	}
+	return orderResult, nil
+}
+
+// getPaypalLink 按 rel 查找PayPal响应中的链接
+// links: 响应中的链接列表
+// rels: 按优先级排列的 rel 值
+// 返回第一个匹配的链接地址，未找到时返回空字符串
+func getPaypalLink(links []*paypal.Link, rels ...string) string {
+	for _, rel := range rels {
+		for _, link := range links {
+			if link != nil && link.Rel == rel && link.Href != "" {
+				return link.Href
+			}
+		}
+	}
+	return ""
+}
+
+// getPaypalOrderState 根据PayPal订单状态获取支付状态
+// status: 订单状态
+// 返回支付状态，未知状态返回 PaymentStateError
+func getPaypalOrderState(status string) PaymentState {
+	switch status {
+	case "CREATED", "SAVED", "APPROVED", "PAYER_ACTION_REQUIRED":
+		// 订单已创建或已批准但尚未捕获
+		return PaymentStateCreated
+	case "VOIDED":
+		// 订单已作废
+		return PaymentStateCanceled
+	case "COMPLETED":
+		// 订单已完成
+		return PaymentStatePaid
+	default:
+		return PaymentStateError
+	}
+}
+
+// getPaypalCaptureState 根据PayPal捕获状态获取支付状态
+// status: 捕获状态
+// 返回支付状态，捕获被拒绝或失败时返回 PaymentStateError
+func getPaypalCaptureState(status string) PaymentState {
+	switch status {
+	case "COMPLETED", "PARTIALLY_REFUNDED", "REFUNDED":
+		// 已扣款，退款不影响原支付状态
+		return PaymentStatePaid
+	case "PENDING":
+		// 扣款处理中
+		return PaymentStateCreated
+	default:
+		return PaymentStateError
+	}
+}
+
+// Refund 处理PayPal退款请求
+// 先查询订单的捕获记录，再对已完成的捕获发起退款
+// ctx: 上下文
+// r: 退款请求参数
+// 返回退款响应和可能的错误
+func (pp *PaypalPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	requestId, err := getRefundRequestNo(ProviderTypePaypal, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 查询订单捕获记录
+	var order paypalOrder
+	err = pp.doRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/checkout/orders/%s", r.OrderId), "", nil, &order)
+	if err != nil {
+		return nil, err
+	}
+	var capture *paypalCapture
+	for _, unit := range order.PurchaseUnits {
+		if capture != nil {
+			break
+		}
+		for _, c := range unit.Payments.Captures {
+			if c != nil && (c.Status == "COMPLETED" || c.Status == "PARTIALLY_REFUNDED") {
+				capture = c
+				break
+			}
+		}
+	}
+	if capture == nil {
+		return nil, fmt.Errorf("no refundable paypal capture found for order: %s", r.OrderId)
+	}
+
+	// 构建退款请求体，未指定金额时PayPal退还捕获的剩余金额
+	bm := make(gopay.BodyMap)
+	if !r.Price.IsZero() {
+		bm.Set("amount", &paypal.Amount{
+			CurrencyCode: r.Price.Currency,
+			Value:        r.Price.String(),
+		})
+	}
+	if r.Reason != "" {
+		bm.Set("note_to_payer", r.Reason)
+	}
+
+	// 发起退款，PayPal-Request-Id 用于幂等控制
+	var refund paypal.PaymentCaptureRefund
+	err = pp.doRequest(ctx, http.MethodPost, fmt.Sprintf("/v2/payments/captures/%s/refund", capture.Id), requestId, bm, &refund)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据退款状态设置结果
+	var refundState RefundState
+	switch refund.Status {
+	case "COMPLETED": // 退款完成
+		refundState = RefundStateSucceeded
+	case "PENDING": // 退款处理中
+		refundState = RefundStatePending
+	case "CANCELLED": // 退款已取消
+		refundState = RefundStateCanceled
+	default: // 退款失败
+		refundState = RefundStateFailed
+	}
+
+	refundResp := &RefundResp{
+		RefundId:    refund.Id,
+		RefundState: refundState,
+	}
+	if refund.Amount != nil {
+		price, err := ParseMoney(refund.Amount.Value, refund.Amount.CurrencyCode)
+		if err != nil {
+			return nil, err
+		}
+		refundResp.Price = price
+	}
+	return refundResp, nil
+}
+
+// doRequest 调用PayPal REST接口
+// 使用 APIEndpoint 作为接口地址和缓存的访问令牌；令牌被PayPal拒绝（401）时刷新令牌并重试一次
+// ctx: 上下文
+// method: HTTP方法
+// path: 接口路径
+// requestId: PayPal-Request-Id 幂等键，为空时不设置
+// body: 请求体，为nil时不发送
+// result: 响应体解析目标，为nil时不解析
+// 返回可能的错误
+func (pp *PaypalPaymentProvider) doRequest(ctx context.Context, method string, path string, requestId string, body interface{}, result interface{}) error {
+	// 序列化请求体，重试时复用
+	var bodyBytes []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		bodyBytes = b
+	}
+
+	token, err := pp.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	status, respBytes, err := pp.sendRequest(ctx, method, path, requestId, token, bodyBytes)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusUnauthorized {
+		// 令牌可能已在PayPal侧失效，丢弃缓存后重试
+		pp.invalidateAccessToken(token)
+		if token, err = pp.getAccessToken(ctx); err != nil {
+			return err
+		}
+		if status, respBytes, err = pp.sendRequest(ctx, method, path, requestId, token, bodyBytes); err != nil {
+			return err
+		}
+	}
+
+	// 非2xx状态码时解析PayPal错误响应
+	if status < 200 || status >= 300 {
+		errRsp := &paypal.ErrorResponse{}
+		if json.Unmarshal(respBytes, errRsp) != nil {
+			errRsp = nil
+		}
+		return wrapPaypalError(status, errRsp, string(respBytes))
+	}
+
+	// 解析响应体
+	if result == nil || len(respBytes) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(respBytes, result); err != nil {
+		return wrapError(ProviderTypePaypal, fmt.Errorf("invalid paypal response: %w", err))
+	}
+	return nil
+}
+
+// sendRequest 发送一次PayPal REST请求
+// ctx: 上下文
+// method: HTTP方法
+// path: 接口路径
+// requestId: PayPal-Request-Id 幂等键，为空时不设置
+// token: 访问令牌
+// body: 序列化后的请求体，为nil时不发送
+// 返回HTTP状态码、响应体和可能的错误
+func (pp *PaypalPaymentProvider) sendRequest(ctx context.Context, method string, path string, requestId string, token string, body []byte) (int, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, pp.APIEndpoint+path, reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", paypal.AuthorizationPrefixBearer+token)
+	req.Header.Set("Content-Type", "application/json")
+	if requestId != "" {
+		req.Header.Set("PayPal-Request-Id", requestId)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, wrapError(ProviderTypePaypal, err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, wrapError(ProviderTypePaypal, err)
+	}
+	return resp.StatusCode, respBytes, nil
+}
+
+// paypalTokenResp PayPal访问令牌响应结构体
+type paypalTokenResp struct {
+	AccessToken string `json:"access_token"` // 访问令牌
+	ExpiresIn   int    `json:"expires_in"`   // 有效期，单位为秒
+}
+
+// getAccessToken 获取PayPal访问令牌
+// 缓存的令牌未到刷新时间时直接返回，否则使用客户端ID和密钥重新获取
+// 参考文档: https://developer.paypal.com/api/rest/authentication/
+// ctx: 上下文
+// 返回访问令牌和可能的错误
+func (pp *PaypalPaymentProvider) getAccessToken(ctx context.Context) (string, error) {
+	pp.tokenMutex.Lock()
+	defer pp.tokenMutex.Unlock()
+	if pp.accessToken != "" && time.Now().Before(pp.tokenExpiry) {
+		return pp.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pp.APIEndpoint+"/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(pp.Client.Clientid, pp.Client.Secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", wrapError(ProviderTypePaypal, err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", wrapError(ProviderTypePaypal, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errRsp := &paypal.ErrorResponse{}
+		if json.Unmarshal(respBytes, errRsp) != nil {
+			errRsp = nil
+		}
+		return "", wrapPaypalError(resp.StatusCode, errRsp, string(respBytes))
+	}
+
+	var tokenResp paypalTokenResp
+	if err = json.Unmarshal(respBytes, &tokenResp); err != nil {
+		return "", wrapError(ProviderTypePaypal, fmt.Errorf("invalid paypal token response: %w", err))
+	}
+	if tokenResp.AccessToken == "" {
+		return "", newError(ProviderTypePaypal, ErrorCodeAuthFailed, "", "token response has no access_token")
+	}
+	pp.accessToken = tokenResp.AccessToken
+	pp.tokenExpiry = getPaypalTokenExpiry(tokenResp.ExpiresIn, time.Now())
+	return pp.accessToken, nil
+}
+
+// invalidateAccessToken 丢弃被PayPal拒绝的访问令牌
+// 令牌已被其他请求刷新时保留新令牌
+// token: 被拒绝的访问令牌
+func (pp *PaypalPaymentProvider) invalidateAccessToken(token string) {
+	pp.tokenMutex.Lock()
+	defer pp.tokenMutex.Unlock()
+	if pp.accessToken == token {
+		pp.accessToken = ""
+	}
+}
+
+// getPaypalTokenExpiry 计算PayPal访问令牌需要刷新的时间
+// 提前 paypalTokenRefreshMargin 刷新令牌；有效期短于刷新时间时在有效期过半时刷新，有效期缺失时缓存 paypalTokenFallbackTTL
+// expiresIn: 令牌有效期，单位为秒
+// now: 当前时间
+// 返回令牌需要刷新的时间
+func getPaypalTokenExpiry(expiresIn int, now time.Time) time.Time {
+	if expiresIn <= 0 {
+		return now.Add(paypalTokenFallbackTTL)
+	}
+	lifetime := time.Duration(expiresIn) * time.Second
+	if lifetime <= paypalTokenRefreshMargin {
+		return now.Add(lifetime / 2)
+	}
+	return now.Add(lifetime - paypalTokenRefreshMargin)
+}
 // GetResponseError 获取响应错误信息
 // err: 错误对象
 // 返回错误描述字符串
@@ -201,3 +810,58 @@ func (pp *PaypalPaymentProvider) GetResponseError(err error) string {
 		return "fail" // 失败
 	}
 }
+
+// paypalErrorCodes PayPal错误名称（name）及错误详情（issue）与支付错误码的映射
+var paypalErrorCodes = map[string]ErrorCode{
+	"INVALID_REQUEST":         ErrorCodeInvalidRequest,
+	"UNPROCESSABLE_ENTITY":    ErrorCodeInvalidRequest,
+	"AUTHENTICATION_FAILURE":  ErrorCodeAuthFailed,
+	"NOT_AUTHORIZED":          ErrorCodeAuthFailed,
+	"PERMISSION_DENIED":       ErrorCodeAuthFailed,
+	"RESOURCE_NOT_FOUND":      ErrorCodeNotFound,
+	"INVALID_RESOURCE_ID":     ErrorCodeNotFound,
+	"DUPLICATE_INVOICE_ID":    ErrorCodeDuplicateOrder,
+	"DUPLICATE_REQUEST_ID":    ErrorCodeDuplicateOrder,
+	"ORDER_ALREADY_CAPTURED":  ErrorCodeDuplicateOrder,
+	"CAPTURE_FULLY_REFUNDED":  ErrorCodeDuplicateOrder,
+	"ORDER_ALREADY_COMPLETED": ErrorCodeDuplicateOrder,
+	"ORDER_EXPIRED":           ErrorCodeOrderClosed,
+	"ORDER_NOT_APPROVED":      ErrorCodeInvalidRequest,
+	"INSTRUMENT_DECLINED":     ErrorCodeCardDeclined,
+	"TRANSACTION_REFUSED":     ErrorCodeCardDeclined,
+	"PAYER_CANNOT_PAY":        ErrorCodeCardDeclined,
+	"INSUFFICIENT_FUNDS":      ErrorCodeInsufficientFunds,
+	"RATE_LIMIT_REACHED":      ErrorCodeRateLimited,
+	"INTERNAL_SERVER_ERROR":   ErrorCodeProviderUnavailable,
+	"INTERNAL_SERVICE_ERROR":  ErrorCodeProviderUnavailable,
+	"SERVICE_UNAVAILABLE":     ErrorCodeProviderUnavailable,
+}
+
+// wrapPaypalError 将PayPal错误响应映射为支付错误
+// 优先按第一条错误详情的 issue 分类，其次按错误名称，最后按HTTP状态码
+// status: HTTP状态码
+// errRsp: PayPal错误响应，可能为nil
+// body: 原始响应体，无法解析错误响应时作为错误信息
+// 返回支付错误
+func wrapPaypalError(status int, errRsp *paypal.ErrorResponse, body string) error {
+	if errRsp == nil || errRsp.Name == "" {
+		return newError(ProviderTypePaypal, getErrorCodeByHTTPStatus(status), "", body)
+	}
+
+	vendorCode, vendorMessage := errRsp.Name, errRsp.Message
+	if len(errRsp.Details) > 0 && errRsp.Details[0].Issue != "" {
+		vendorCode = errRsp.Details[0].Issue
+		if errRsp.Details[0].Description != "" {
+			vendorMessage = errRsp.Details[0].Description
+		}
+	}
+
+	code, ok := paypalErrorCodes[vendorCode]
+	if !ok {
+		code, ok = paypalErrorCodes[errRsp.Name]
+	}
+	if !ok {
+		code = getErrorCodeByHTTPStatus(status)
+	}
+	return newError(ProviderTypePaypal, code, vendorCode, vendorMessage)
+}
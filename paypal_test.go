@@ -0,0 +1,545 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pay/gopay/paypal"
+)
+
+// newTestPaypalProvider 创建指向本地替身服务的PayPal提供商
+// 替身服务的令牌接口返回 test_token，其他接口由 handler 处理
+func newTestPaypalProvider(t *testing.T, handler http.HandlerFunc) *PaypalPaymentProvider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/oauth2/token", paypalTokenStub(t, "test_token"))
+	mux.HandleFunc("/", handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &PaypalPaymentProvider{
+		Client:      &paypal.Client{Clientid: "client_id", Secret: "secret"},
+		APIEndpoint: server.URL,
+		WebhookId:   "WH-TEST",
+	}
+}
+
+// paypalTokenStub 返回校验客户端凭证并依次签发访问令牌的处理函数
+// 令牌用完后重复签发最后一个
+func paypalTokenStub(t *testing.T, tokens ...string) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientId, secret, ok := r.BasicAuth()
+		if !ok || clientId != "client_id" || secret != "secret" {
+			t.Errorf("BasicAuth = %q, %q, %v, want client_id, secret", clientId, secret, ok)
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			t.Errorf("grant_type = %q, want client_credentials", r.PostForm.Get("grant_type"))
+		}
+		mu.Lock()
+		token := tokens[0]
+		if len(tokens) > 1 {
+			tokens = tokens[1:]
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"`+token+`","token_type":"Bearer","expires_in":32400}`)
+	}
+}
+
+// paypalStub 返回校验请求并以固定状态码和响应体应答的处理函数
+func paypalStub(t *testing.T, method string, path string, status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method || r.URL.Path != path {
+			t.Errorf("unexpected request: %s %s, want %s %s", r.Method, r.URL.Path, method, path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test_token" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer test_token")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+func TestPaypalPay(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantUrl  string
+		wantCode ErrorCode
+	}{
+		{
+			name:    "approve link after other links",
+			status:  http.StatusCreated,
+			body:    `{"id":"5O190127TN364715T","status":"CREATED","links":[{"href":"https://api/self","rel":"self"},{"href":"https://paypal/approve","rel":"approve"}]}`,
+			wantUrl: "https://paypal/approve",
+		},
+		{
+			name:    "payer action link",
+			status:  http.StatusOK,
+			body:    `{"id":"5O190127TN364715T","status":"PAYER_ACTION_REQUIRED","links":[{"href":"https://paypal/payer-action","rel":"payer-action"}]}`,
+			wantUrl: "https://paypal/payer-action",
+		},
+		{
+			name:     "only self link",
+			status:   http.StatusCreated,
+			body:     `{"id":"5O190127TN364715T","links":[{"href":"https://api/self","rel":"self"}]}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "no links",
+			status:   http.StatusCreated,
+			body:     `{"id":"5O190127TN364715T"}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "null link and approve link without href",
+			status:   http.StatusCreated,
+			body:     `{"id":"5O190127TN364715T","links":[null,{"rel":"approve"}]}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "malformed links",
+			status:   http.StatusCreated,
+			body:     `{"id":"5O190127TN364715T","links":"https://paypal/approve"}`,
+			wantCode: ErrorCodeUnknown,
+		},
+		{
+			name:     "missing order id",
+			status:   http.StatusCreated,
+			body:     `{"links":[{"href":"https://paypal/approve","rel":"approve"}]}`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "error without details",
+			status:   http.StatusUnprocessableEntity,
+			body:     `{"name":"UNPROCESSABLE_ENTITY","message":"The requested action could not be performed.","details":[]}`,
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name:     "error detail issue",
+			status:   http.StatusUnprocessableEntity,
+			body:     `{"name":"UNPROCESSABLE_ENTITY","details":[{"issue":"PAYER_CANNOT_PAY","description":"Payer cannot pay for this transaction."}]}`,
+			wantCode: ErrorCodeCardDeclined,
+		},
+		{
+			name:     "rate limited",
+			status:   http.StatusTooManyRequests,
+			body:     `{"name":"RATE_LIMIT_REACHED","message":"Too many requests."}`,
+			wantCode: ErrorCodeRateLimited,
+		},
+		{
+			name:     "non-json server error",
+			status:   http.StatusServiceUnavailable,
+			body:     `upstream connect error`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:     "empty client error",
+			status:   http.StatusUnauthorized,
+			body:     ``,
+			wantCode: ErrorCodeAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newTestPaypalProvider(t, paypalStub(t, http.MethodPost, "/v2/checkout/orders", tt.status, tt.body))
+			payResp, err := pp.Pay(context.Background(), &PayReq{
+				ProductName:        "product",
+				ProductDisplayName: "Product",
+				PaymentName:        "payment_1",
+				Price:              NewMoney(1000, "USD"),
+				ReturnUrl:          "https://example.com/return",
+			})
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if payResp.PayUrl != tt.wantUrl {
+				t.Errorf("PayUrl = %q, want %q", payResp.PayUrl, tt.wantUrl)
+			}
+			if payResp.OrderId != "5O190127TN364715T" {
+				t.Errorf("OrderId = %q, want %q", payResp.OrderId, "5O190127TN364715T")
+			}
+		})
+	}
+}
+
+func TestPaypalQueryOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus PaymentState
+		wantPrice  Money
+		wantCode   ErrorCode
+		wantErr    bool
+	}{
+		{
+			name:       "completed order",
+			status:     http.StatusOK,
+			body:       `{"id":"ORDER1","status":"COMPLETED","purchase_units":[{"amount":{"currency_code":"USD","value":"10.00"},"payments":{"captures":[{"id":"CAPTURE1","status":"COMPLETED","amount":{"currency_code":"USD","value":"10.00"}}]}}]}`,
+			wantStatus: PaymentStatePaid,
+			wantPrice:  NewMoney(1000, "USD"),
+		},
+		{
+			name:       "pending capture",
+			status:     http.StatusOK,
+			body:       `{"id":"ORDER1","status":"COMPLETED","purchase_units":[{"amount":{"currency_code":"USD","value":"10.00"},"payments":{"captures":[{"id":"CAPTURE1","status":"PENDING"}]}}]}`,
+			wantStatus: PaymentStateCreated,
+			wantPrice:  NewMoney(1000, "USD"),
+		},
+		{
+			name:    "missing purchase units",
+			status:  http.StatusOK,
+			body:    `{"id":"ORDER1","status":"COMPLETED"}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty purchase units",
+			status:  http.StatusOK,
+			body:    `{"id":"ORDER1","status":"COMPLETED","purchase_units":[]}`,
+			wantErr: true,
+		},
+		{
+			name:     "resource not found",
+			status:   http.StatusNotFound,
+			body:     `{"name":"RESOURCE_NOT_FOUND","details":[{"issue":"INVALID_RESOURCE_ID","description":"Specified resource ID does not exist."}]}`,
+			wantCode: ErrorCodeNotFound,
+		},
+		{
+			name:     "error without name",
+			status:   http.StatusBadRequest,
+			body:     `{"details":[]}`,
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name:     "html server error",
+			status:   http.StatusBadGateway,
+			body:     `<html>Bad Gateway</html>`,
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newTestPaypalProvider(t, paypalStub(t, http.MethodGet, "/v2/checkout/orders/ORDER1", tt.status, tt.body))
+			orderResult, err := pp.QueryOrder(context.Background(), "ORDER1")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if orderResult.PaymentStatus != tt.wantStatus {
+				t.Errorf("PaymentStatus = %s, want %s", orderResult.PaymentStatus, tt.wantStatus)
+			}
+			if orderResult.Price != tt.wantPrice {
+				t.Errorf("Price = %v, want %v", orderResult.Price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestWrapPaypalError(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		errRsp         *paypal.ErrorResponse
+		body           string
+		wantCode       ErrorCode
+		wantVendorCode string
+	}{
+		{
+			name:     "nil response",
+			status:   http.StatusInternalServerError,
+			body:     "internal error",
+			wantCode: ErrorCodeProviderUnavailable,
+		},
+		{
+			name:           "name without details",
+			status:         http.StatusForbidden,
+			errRsp:         &paypal.ErrorResponse{Name: "NOT_AUTHORIZED"},
+			wantCode:       ErrorCodeAuthFailed,
+			wantVendorCode: "NOT_AUTHORIZED",
+		},
+		{
+			name:           "detail without issue",
+			status:         http.StatusUnprocessableEntity,
+			errRsp:         &paypal.ErrorResponse{Name: "UNPROCESSABLE_ENTITY", Details: []paypal.ErrorDetail{{Description: "no issue"}}},
+			wantCode:       ErrorCodeInvalidRequest,
+			wantVendorCode: "UNPROCESSABLE_ENTITY",
+		},
+		{
+			name:           "unknown issue falls back to name",
+			status:         http.StatusUnprocessableEntity,
+			errRsp:         &paypal.ErrorResponse{Name: "RESOURCE_NOT_FOUND", Details: []paypal.ErrorDetail{{Issue: "SOMETHING_NEW"}}},
+			wantCode:       ErrorCodeNotFound,
+			wantVendorCode: "SOMETHING_NEW",
+		},
+		{
+			name:           "unknown name falls back to status",
+			status:         http.StatusConflict,
+			errRsp:         &paypal.ErrorResponse{Name: "SOMETHING_NEW"},
+			wantCode:       ErrorCodeDuplicateOrder,
+			wantVendorCode: "SOMETHING_NEW",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapPaypalError(tt.status, tt.errRsp, tt.body)
+			checkPaymentError(t, err, tt.wantCode)
+			var payErr *Error
+			errors.As(err, &payErr)
+			if payErr.VendorCode != tt.wantVendorCode {
+				t.Errorf("VendorCode = %q, want %q", payErr.VendorCode, tt.wantVendorCode)
+			}
+		})
+	}
+}
+
+func TestPaypalAccessTokenRefresh(t *testing.T) {
+	var tokenRequests, apiRequests atomic.Int32
+	mux := http.NewServeMux()
+	tokenStub := paypalTokenStub(t, "new_token")
+	mux.HandleFunc("/v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		tokenStub(w, r)
+	})
+	mux.HandleFunc("/v2/checkout/orders/ORDER1", func(w http.ResponseWriter, r *http.Request) {
+		apiRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer new_token" {
+			// 已过期的令牌被PayPal拒绝
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"error":"invalid_token","error_description":"Token signature verification failed"}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":"ORDER1","status":"CREATED","purchase_units":[{"amount":{"currency_code":"USD","value":"10.00"}}]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	// 缓存中的令牌尚未到刷新时间，但已在PayPal侧失效
+	pp := &PaypalPaymentProvider{
+		Client:      &paypal.Client{Clientid: "client_id", Secret: "secret"},
+		APIEndpoint: server.URL,
+		accessToken: "expired_token",
+		tokenExpiry: time.Now().Add(time.Hour),
+	}
+	if _, err := pp.QueryOrder(context.Background(), "ORDER1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenRequests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+	if got := apiRequests.Load(); got != 2 {
+		t.Errorf("api requests = %d, want 2", got)
+	}
+
+	// 刷新后的令牌被缓存
+	if _, err := pp.QueryOrder(context.Background(), "ORDER1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenRequests.Load(); got != 1 {
+		t.Errorf("token requests after cached call = %d, want 1", got)
+	}
+
+	// 缓存的令牌到达刷新时间后重新获取
+	pp.tokenExpiry = time.Now().Add(-time.Second)
+	if _, err := pp.QueryOrder(context.Background(), "ORDER1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenRequests.Load(); got != 2 {
+		t.Errorf("token requests after expiry = %d, want 2", got)
+	}
+}
+
+func TestPaypalAccessTokenRejected(t *testing.T) {
+	var apiRequests atomic.Int32
+	pp := newTestPaypalProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		apiRequests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	_, err := pp.QueryOrder(context.Background(), "ORDER1")
+	checkPaymentError(t, err, ErrorCodeAuthFailed)
+	// 刷新令牌后只重试一次
+	if got := apiRequests.Load(); got != 2 {
+		t.Errorf("api requests = %d, want 2", got)
+	}
+}
+
+func TestGetPaypalTokenExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		expiresIn int
+		want      time.Time
+	}{
+		{
+			name:      "refresh before expiry",
+			expiresIn: 32400,
+			want:      now.Add(9*time.Hour - paypalTokenRefreshMargin),
+		},
+		{
+			name:      "shorter than refresh margin",
+			expiresIn: 120,
+			want:      now.Add(time.Minute),
+		},
+		{
+			name:      "missing expires_in",
+			expiresIn: 0,
+			want:      now.Add(paypalTokenFallbackTTL),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getPaypalTokenExpiry(tt.expiresIn, now); !got.Equal(tt.want) {
+				t.Errorf("expiry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaypalNotify(t *testing.T) {
+	const verified = `{"verification_status":"SUCCESS"}`
+	tests := []struct {
+		name         string
+		verifyStatus int
+		verify       string
+		event        string
+		capture      string
+		status       int
+		wantStatus   PaymentState
+		wantSig      bool
+		wantCode     ErrorCode
+		wantErr      bool
+	}{
+		{
+			name:       "capture completed",
+			verify:     verified,
+			event:      `{"id":"WH-1","event_type":"PAYMENT.CAPTURE.COMPLETED","resource":{"id":"CAPTURE1","status":"COMPLETED","amount":{"currency_code":"USD","value":"10.00"},"supplementary_data":{"related_ids":{"order_id":"ORDER1"}}}}`,
+			wantStatus: PaymentStatePaid,
+		},
+		{
+			name:       "approved order captured",
+			verify:     verified,
+			event:      `{"id":"WH-1","event_type":"CHECKOUT.ORDER.APPROVED","resource":{"id":"ORDER1","status":"APPROVED","purchase_units":[]}}`,
+			status:     http.StatusCreated,
+			capture:    `{"id":"ORDER1","status":"COMPLETED","purchase_units":[{"amount":{"currency_code":"USD","value":"10.00"},"payments":{"captures":[{"id":"CAPTURE1","status":"COMPLETED","amount":{"currency_code":"USD","value":"10.00"}}]}}]}`,
+			wantStatus: PaymentStatePaid,
+		},
+		{
+			name:    "verification failed",
+			verify:  `{"verification_status":"FAILURE"}`,
+			event:   `{"id":"WH-1","event_type":"PAYMENT.CAPTURE.COMPLETED","resource":{}}`,
+			wantSig: true,
+		},
+		{
+			name:         "verification request rejected",
+			verifyStatus: http.StatusBadRequest,
+			verify:       `{"name":"VALIDATION_ERROR","details":[]}`,
+			event:        `{"id":"WH-1","event_type":"PAYMENT.CAPTURE.COMPLETED","resource":{}}`,
+			wantCode:     ErrorCodeInvalidRequest,
+		},
+		{
+			name:    "order event with empty purchase units",
+			verify:  verified,
+			event:   `{"id":"WH-1","event_type":"CHECKOUT.ORDER.COMPLETED","resource":{"id":"ORDER1","status":"COMPLETED","purchase_units":[]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "captured order with empty purchase units",
+			verify:  verified,
+			event:   `{"id":"WH-1","event_type":"CHECKOUT.ORDER.APPROVED","resource":{"id":"ORDER1","status":"APPROVED"}}`,
+			status:  http.StatusCreated,
+			capture: `{"id":"ORDER1","status":"COMPLETED","purchase_units":[]}`,
+			wantErr: true,
+		},
+		{
+			name:     "capture error with empty details",
+			verify:   verified,
+			event:    `{"id":"WH-1","event_type":"CHECKOUT.ORDER.APPROVED","resource":{"id":"ORDER1","status":"APPROVED"}}`,
+			status:   http.StatusUnprocessableEntity,
+			capture:  `{"name":"UNPROCESSABLE_ENTITY","message":"The requested action could not be performed.","details":[]}`,
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name:       "order not approved",
+			verify:     verified,
+			event:      `{"id":"WH-1","event_type":"CHECKOUT.ORDER.APPROVED","resource":{"id":"ORDER1","status":"APPROVED"}}`,
+			status:     http.StatusUnprocessableEntity,
+			capture:    `{"name":"UNPROCESSABLE_ENTITY","details":[{"issue":"ORDER_NOT_APPROVED","description":"Payer has not yet approved the Order for payment."}]}`,
+			wantStatus: PaymentStateCanceled,
+		},
+		{
+			name:    "order id mismatch",
+			verify:  verified,
+			event:   `{"id":"WH-1","event_type":"PAYMENT.CAPTURE.COMPLETED","resource":{"id":"CAPTURE1","status":"COMPLETED","supplementary_data":{"related_ids":{"order_id":"ORDER2"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported event",
+			verify:  verified,
+			event:   `{"id":"WH-1","event_type":"BILLING.PLAN.CREATED","resource":{}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newTestPaypalProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/notifications/verify-webhook-signature":
+					if tt.verifyStatus != 0 {
+						w.WriteHeader(tt.verifyStatus)
+					}
+					_, _ = io.WriteString(w, tt.verify)
+				case "/v2/checkout/orders/ORDER1/capture":
+					if tt.capture == "" {
+						t.Errorf("unexpected capture request")
+					}
+					w.WriteHeader(tt.status)
+					_, _ = io.WriteString(w, tt.capture)
+				default:
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			notifyResult, err := pp.Notify(context.Background(), http.Header{}, []byte(tt.event), "ORDER1")
+			switch {
+			case tt.wantSig:
+				if !errors.Is(err, ErrInvalidNotifySignature) {
+					t.Fatalf("error = %v, want ErrInvalidNotifySignature", err)
+				}
+				return
+			case tt.wantErr:
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if notifyResult.PaymentStatus != tt.wantStatus {
+				t.Errorf("PaymentStatus = %s, want %s", notifyResult.PaymentStatus, tt.wantStatus)
+			}
+			if notifyResult.OrderId != "ORDER1" {
+				t.Errorf("OrderId = %q, want %q", notifyResult.OrderId, "ORDER1")
+			}
+		})
+	}
+}
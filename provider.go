@@ -2,6 +2,14 @@
 // 提供多种支付方式的统一接口，包括支付宝、微信支付、Stripe等
 package payment
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
 // PaymentState 支付状态类型
 type PaymentState string
 
@@ -14,11 +22,143 @@ const (
 	PaymentStateError    PaymentState = "Error"    // 错误
 )
 
+// RefundState 退款状态类型
+type RefundState string
+
+// 退款状态常量定义
+const (
+	RefundStateSucceeded RefundState = "Succeeded" // 退款成功
+	RefundStatePending   RefundState = "Pending"   // 退款处理中
+	RefundStateFailed    RefundState = "Failed"    // 退款失败
+	RefundStateCanceled  RefundState = "Canceled"  // 退款已取消
+)
+
 // 支付环境常量定义
+// 决定提供商使用的支付方式，空值为电脑网站支付或扫码支付
+const (
+	PaymentEnvWechatBrowser     = "WechatBrowser"     // 微信浏览器环境
+	PaymentEnvWechatMiniProgram = "WechatMiniProgram" // 微信小程序环境
+	PaymentEnvMobileBrowser     = "MobileBrowser"     // 手机浏览器环境
+	PaymentEnvApp               = "App"               // 原生应用环境，由客户端SDK拉起支付
+	PaymentEnvQrCode            = "QrCode"            // 当面付扫码环境，PayUrl为二维码内容
+	PaymentEnvEmbedded          = "Embedded"          // 嵌入式收银台环境，PayUrl为空，AttachInfo为前端SDK所需参数
+)
+
+// Environment 网关环境类型
+// 决定提供商使用的接口地址，空值视为正式环境
+type Environment string
+
+// 网关环境常量定义
+const (
+	EnvironmentProduction Environment = "production" // 正式环境
+	EnvironmentSandbox    Environment = "sandbox"    // 沙箱环境
+)
+
+// Validate 校验网关环境
+// 返回:
+//   - error: 未知环境时返回错误
+func (env Environment) Validate() error {
+	switch env {
+	case "", EnvironmentProduction, EnvironmentSandbox:
+		return nil
+	default:
+		return fmt.Errorf("unknown payment environment: %q", string(env))
+	}
+}
+
+// IsProd 判断是否为正式环境
+func (env Environment) IsProd() bool {
+	return env != EnvironmentSandbox
+}
+
+// ExperienceContext 收银台体验配置
+// 提供商上的配置为默认值，支付请求中的非空字段覆盖默认值；空字段不发送，由支付平台使用默认值
+type ExperienceContext struct {
+	BrandName          string `json:"brandName,omitempty" yaml:"brandName,omitempty"`                   // 品牌名称，展示给买家
+	Locale             string `json:"locale,omitempty" yaml:"locale,omitempty"`                         // 语言环境，例如 "zh-CN"、"en-US"
+	LandingPage        string `json:"landingPage,omitempty" yaml:"landingPage,omitempty"`               // 着陆页（PayPal）：LOGIN、GUEST_CHECKOUT、NO_PREFERENCE
+	ShippingPreference string `json:"shippingPreference,omitempty" yaml:"shippingPreference,omitempty"` // 收货地址（PayPal）：GET_FROM_FILE、NO_SHIPPING、SET_PROVIDED_ADDRESS
+	UserAction         string `json:"userAction,omitempty" yaml:"userAction,omitempty"`                 // 买家操作（PayPal）：CONTINUE、PAY_NOW
+}
+
+// merge 合并收银台体验配置
+// 参数:
+//   - override: 覆盖的配置，为nil时返回默认配置
+//
+// 返回:
+//   - ExperienceContext: 合并后的配置
+func (c ExperienceContext) merge(override *ExperienceContext) ExperienceContext {
+	if override == nil {
+		return c
+	}
+	if override.BrandName != "" {
+		c.BrandName = override.BrandName
+	}
+	if override.Locale != "" {
+		c.Locale = override.Locale
+	}
+	if override.LandingPage != "" {
+		c.LandingPage = override.LandingPage
+	}
+	if override.ShippingPreference != "" {
+		c.ShippingPreference = override.ShippingPreference
+	}
+	if override.UserAction != "" {
+		c.UserAction = override.UserAction
+	}
+	return c
+}
+
+// 订阅计费周期单位常量定义
 const (
-	PaymentEnvWechatBrowser = "WechatBrowser" // 微信浏览器环境
+	RecurringIntervalDay   = "day"   // 天
+	RecurringIntervalWeek  = "week"  // 周
+	RecurringIntervalMonth = "month" // 月
+	RecurringIntervalYear  = "year"  // 年
 )
 
+// Recurring 订阅计费周期结构体
+// 支付请求携带计费周期时创建订阅而非一次性支付，目前仅Stripe支持
+type Recurring struct {
+	Interval      string // 计费周期单位：day、week、month、year
+	IntervalCount int64  // 每个计费周期包含的单位数量，0视为1，例如 Interval 为 month、IntervalCount 为3表示每季度
+	TrialDays     int64  // 试用天数，0表示不试用，仅创建订阅时有效
+}
+
+// Validate 校验订阅计费周期
+// 返回:
+//   - error: 计费周期单位未知或数量为负数时返回错误
+func (r *Recurring) Validate() error {
+	switch r.Interval {
+	case RecurringIntervalDay, RecurringIntervalWeek, RecurringIntervalMonth, RecurringIntervalYear:
+	default:
+		return fmt.Errorf("unknown recurring interval: %q", r.Interval)
+	}
+	if r.IntervalCount < 0 {
+		return fmt.Errorf("invalid recurring interval count: %d", r.IntervalCount)
+	}
+	if r.TrialDays < 0 {
+		return fmt.Errorf("invalid recurring trial days: %d", r.TrialDays)
+	}
+	return nil
+}
+
+// ErrInvalidNotifySignature 通知签名校验失败错误
+// 所有提供商在通知验签失败时返回包装了该错误的错误信息，可通过 errors.Is 判断
+var ErrInvalidNotifySignature = errors.New("invalid notification signature")
+
+// ErrProviderNotConfigured 提供商未配置错误
+// 构造函数缺少必要参数时返回包装了该错误的错误信息，已禁用的提供商的所有方法也返回该错误
+var ErrProviderNotConfigured = errors.New("payment provider is not configured")
+
+// ErrNotSupported 提供商不支持该操作错误
+// 支付平台未提供相应接口时返回，可通过 errors.Is 判断
+var ErrNotSupported = errors.New("operation not supported by payment provider")
+
+// ErrInvoicePending 发票开具中错误
+// 支付平台异步开票且发票尚未开具时返回，可通过 errors.Is 判断后稍后重新获取
+var ErrInvoicePending = errors.New("invoice is being issued")
+
 // PayReq 支付请求结构体
 // 包含支付所需的所有参数信息
 type PayReq struct {
@@ -31,13 +171,30 @@ type PayReq struct {
 	ProductDisplayName string  // 产品显示名称
 	ProductDescription string  // 产品描述
 	ProductImage       string  // 产品图片
-	Price              float64 // 价格
-	Currency           string  // 货币类型
+	Price              Money   // 价格（含货币类型）
+
+	Metadata map[string]string // 商户自定义元数据，会在通知结果中原样返回
 
 	ReturnUrl string // 返回URL
+	FailUrl   string // 支付失败或取消时的返回URL，为空时使用 ReturnUrl
 	NotifyUrl string // 通知URL
 
 	PaymentEnv string // 支付环境
+	ClientIp   string // 付款人客户端IP，微信H5支付必填
+
+	Experience *ExperienceContext // 收银台体验配置，非空字段覆盖提供商的默认配置
+
+	Recurring *Recurring // 订阅计费周期，为nil时为一次性支付，不支持订阅的提供商返回 ErrNotSupported
+}
+
+// getFailUrl 获取支付失败或取消时的返回URL
+// 返回:
+//   - string: FailUrl，为空时为 ReturnUrl
+func (r *PayReq) getFailUrl() string {
+	if r.FailUrl != "" {
+		return r.FailUrl
+	}
+	return r.ReturnUrl
 }
 
 // PayResp 支付响应结构体
@@ -58,10 +215,81 @@ type NotifyResult struct {
 	ProductName        string  // 产品名称
 	ProductDisplayName string  // 产品显示名称
 	ProviderName       string  // 支付提供商名称
-	Price              float64 // 价格
-	Currency           string  // 货币类型
+	Price              Money   // 价格（含货币类型）
+
+	Metadata map[string]string // 商户自定义元数据，即支付请求中的 Metadata
+
+	OrderId        string // 订单ID
+	SubscriptionId string // 订阅ID，仅订阅订单和订阅通知
+
+	Refund *RefundResp // 退款结果，仅退款通知，此时 PaymentStatus 为原订单的支付状态
+}
+
+// OrderResult 订单查询结果结构体
+// 由 QueryOrder 返回，仅反映支付平台上的订单状态，查询本身不会改变订单
+type OrderResult struct {
+	OrderId        string       // 订单ID
+	PaymentName    string       // 支付名称
+	PaymentStatus  PaymentState // 支付状态
+	VendorState    string       // 支付平台原始订单状态
+	TransactionId  string       // 支付平台交易号，未支付时可能为空
+	SubscriptionId string       // 订阅ID，仅订阅订单
+
+	ProductName        string  // 产品名称
+	ProductDisplayName string  // 产品显示名称
+	ProviderName       string  // 支付提供商名称
+	Price              Money   // 订单金额（含货币类型）
+	PaidPrice          Money   // 实付金额（含货币类型），未支付时为零值
+
+	Metadata map[string]string // 商户自定义元数据，支付平台不返回时为空
+
+	PayerId    string // 付款人在支付平台的ID
+	PayerName  string // 付款人姓名
+	PayerEmail string // 付款人邮箱
 
-	OrderId string // 订单ID
+	CreateTime time.Time // 订单创建时间，支付平台不返回时为零值
+	PayTime    time.Time // 支付完成时间，未支付时为零值
+}
+
+// toNotifyResult 将订单查询结果转换为通知结果
+// 用于通知内容不足以确定订单状态、需要以查询结果为准的提供商
+// 返回:
+//   - *NotifyResult: 通知结果
+func (r *OrderResult) toNotifyResult() *NotifyResult {
+	notifyResult := &NotifyResult{
+		PaymentName:        r.PaymentName,
+		PaymentStatus:      r.PaymentStatus,
+		ProductName:        r.ProductName,
+		ProductDisplayName: r.ProductDisplayName,
+		ProviderName:       r.ProviderName,
+		Price:              r.Price,
+		Metadata:           r.Metadata,
+		OrderId:            r.OrderId,
+		SubscriptionId:     r.SubscriptionId,
+	}
+	if r.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected order state: %v", r.VendorState)
+	}
+	return notifyResult
+}
+
+// RefundReq 退款请求结构体
+// Price金额为0时表示全额退款，退还订单的剩余金额，否则为部分退款
+type RefundReq struct {
+	OrderId        string // 订单ID，即Pay返回的OrderId
+	PaymentName    string // 支付名称
+	Price          Money  // 退款金额（含货币类型），金额为0表示全额退款
+	RefundedPrice  Money  // 已退款金额（含货币类型），全额退款时从订单金额中扣除；支付宝和微信支付无法查询已退款金额，部分退款后全额退款时必须提供
+	Reason         string // 退款原因
+	IdempotencyKey string // 幂等键，同一退款重复提交时必须保持一致；部分退款必须提供，全额退款为空时根据订单ID生成
+}
+
+// RefundResp 退款响应结构体
+// 包含退款后返回的信息
+type RefundResp struct {
+	RefundId    string      // 支付平台退款单号
+	RefundState RefundState // 退款状态
+	Price       Money       // 退款金额（含货币类型）
 }
 
 // PaymentProvider 支付提供商接口
@@ -69,23 +297,47 @@ type NotifyResult struct {
 type PaymentProvider interface {
 	// Pay 执行支付操作
 	// 参数:
+	//   - ctx: 上下文
 	//   - req: 支付请求信息
 	// 返回:
 	//   - *PayResp: 支付响应信息
 	//   - error: 错误信息
-	Pay(req *PayReq) (*PayResp, error)
+	Pay(ctx context.Context, req *PayReq) (*PayResp, error)
 	
 	// Notify 处理支付通知
+	// 先校验通知签名，签名无效时返回 ErrInvalidNotifySignature
 	// 参数:
-	//   - body: 通知内容
-	//   - orderId: 订单ID
+	//   - ctx: 上下文
+	//   - header: 通知请求头，用于获取签名信息
+	//   - body: 通知请求体原文，验签要求未经修改
+	//   - orderId: 订单ID，为空时以通知内容中的订单ID为准
 	// 返回:
 	//   - *NotifyResult: 通知结果
 	//   - error: 错误信息
-	Notify(body []byte, orderId string) (*NotifyResult, error)
+	Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error)
+	
+	// QueryOrder 查询订单
+	// 只读取支付平台上的订单信息，不会扣款、确认或关闭订单，可用于对账轮询
+	// 参数:
+	//   - ctx: 上下文
+	//   - orderId: 订单ID，即Pay返回的OrderId
+	// 返回:
+	//   - *OrderResult: 订单查询结果
+	//   - error: 错误信息，提供商不支持查询时返回 ErrNotSupported
+	QueryOrder(ctx context.Context, orderId string) (*OrderResult, error)
+	
+	// CloseOrder 关闭未支付的订单
+	// 关闭后买家无法继续支付，订单查询结果为已取消或已超时；已关闭的订单重复关闭不会报错
+	// 参数:
+	//   - ctx: 上下文
+	//   - orderId: 订单ID，即Pay返回的OrderId
+	// 返回:
+	//   - error: 错误信息，订单已支付时返回错误码为 ErrorCodeDuplicateOrder 的 *Error，提供商不支持时返回 ErrNotSupported
+	CloseOrder(ctx context.Context, orderId string) error
 	
 	// GetInvoice 获取发票
 	// 参数:
+	//   - ctx: 上下文
 	//   - paymentName: 支付名称
 	//   - personName: 个人姓名
 	//   - personIdCard: 身份证号
@@ -97,7 +349,16 @@ type PaymentProvider interface {
 	// 返回:
 	//   - string: 发票信息
 	//   - error: 错误信息
-	GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error)
+	GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error)
+	
+	// Refund 执行退款操作
+	// 参数:
+	//   - ctx: 上下文
+	//   - req: 退款请求信息
+	// 返回:
+	//   - *RefundResp: 退款响应信息
+	//   - error: 错误信息，提供商不支持退款时返回 ErrNotSupported
+	Refund(ctx context.Context, req RefundReq) (*RefundResp, error)
 	
 	// GetResponseError 获取响应错误信息
 	// 参数:
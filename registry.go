@@ -0,0 +1,255 @@
+// Package payment 支付相关功能
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 内置支付提供商类型名称
+const (
+	ProviderTypeAlipay    = "Alipay"     // 支付宝
+	ProviderTypeWechatPay = "WeChat Pay" // 微信支付
+	ProviderTypeStripe    = "Stripe"     // Stripe
+	ProviderTypePaypal    = "PayPal"     // PayPal
+	ProviderTypeAirwallex = "Airwallex"  // Airwallex
+	ProviderTypeGc        = "GC"         // GC
+	ProviderTypeBalance   = "Balance"    // 余额
+	ProviderTypeDummy     = "Dummy"      // 虚拟支付
+)
+
+// ProviderConfig 支付提供商配置
+// 各提供商只使用其中与自身相关的字段，可直接从JSON或YAML配置文件解析
+type ProviderConfig struct {
+	Type        string      `json:"type" yaml:"type"`                                   // 提供商类型名称，例如 "Alipay"、"WeChat Pay"
+	Environment Environment `json:"environment,omitempty" yaml:"environment,omitempty"` // 网关环境，空值视为正式环境；GC的环境由 Host 决定
+	Disabled    bool        `json:"disabled,omitempty" yaml:"disabled,omitempty"`       // 是否禁用，禁用时创建 DisabledPaymentProvider 且不校验凭证
+
+	// 通用凭证
+	AppId        string `json:"appId,omitempty" yaml:"appId,omitempty"`               // 应用ID（支付宝、微信支付）
+	ClientId     string `json:"clientId,omitempty" yaml:"clientId,omitempty"`         // 客户端ID（PayPal、Airwallex）
+	ClientSecret string `json:"clientSecret,omitempty" yaml:"clientSecret,omitempty"` // 客户端密钥（PayPal）
+	ApiKey       string `json:"apiKey,omitempty" yaml:"apiKey,omitempty"`             // API密钥（Airwallex）
+	PrivateKey   string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`     // 应用私钥（支付宝、微信支付）
+
+	// 支付宝
+	AppCertificate   string `json:"appCertificate,omitempty" yaml:"appCertificate,omitempty"`     // 应用公钥证书
+	AlipayPublicCert string `json:"alipayPublicCert,omitempty" yaml:"alipayPublicCert,omitempty"` // 支付宝公钥证书
+	AlipayRootCert   string `json:"alipayRootCert,omitempty" yaml:"alipayRootCert,omitempty"`     // 支付宝根证书
+
+	// 微信支付
+	MchId            string `json:"mchId,omitempty" yaml:"mchId,omitempty"`                       // 商户号
+	ApiV3Key         string `json:"apiV3Key,omitempty" yaml:"apiV3Key,omitempty"`                 // API v3密钥
+	SerialNo         string `json:"serialNo,omitempty" yaml:"serialNo,omitempty"`                 // 商户证书序列号
+	MiniProgramAppId string `json:"miniProgramAppId,omitempty" yaml:"miniProgramAppId,omitempty"` // 小程序AppID，为空时使用 AppId
+	MobileAppId      string `json:"mobileAppId,omitempty" yaml:"mobileAppId,omitempty"`           // 移动应用AppID，为空时使用 AppId
+
+	// Stripe
+	PublishableKey string `json:"publishableKey,omitempty" yaml:"publishableKey,omitempty"` // 可发布密钥
+	SecretKey      string `json:"secretKey,omitempty" yaml:"secretKey,omitempty"`           // 秘密密钥（Stripe、GC）
+
+	// Webhook
+	WebhookSecret string `json:"webhookSecret,omitempty" yaml:"webhookSecret,omitempty"` // Webhook签名密钥（Stripe、Airwallex）
+	WebhookId     string `json:"webhookId,omitempty" yaml:"webhookId,omitempty"`         // Webhook ID（PayPal）
+
+	// 收银台
+	Experience        ExperienceContext        `json:"experience,omitempty" yaml:"experience,omitempty"`               // 收银台体验配置（PayPal）
+	AirwallexCheckout AirwallexCheckoutOptions `json:"airwallexCheckout,omitempty" yaml:"airwallexCheckout,omitempty"` // Airwallex收银台配置
+
+	// GC
+	Xmpch string `json:"xmpch,omitempty" yaml:"xmpch,omitempty"` // 商户号
+	Host  string `json:"host,omitempty" yaml:"host,omitempty"`   // 网关地址
+}
+
+// Require 校验必填配置项
+// 供没有自行校验参数的第三方工厂函数使用，内置提供商由各构造函数校验
+// 参数:
+//   - fields: 必填字段名，例如 "AppId"、"PrivateKey"
+//
+// 返回:
+//   - error: 缺少必填配置项时返回包装了 ErrProviderNotConfigured 的错误
+func (cfg ProviderConfig) Require(fields ...string) error {
+	v := reflect.ValueOf(cfg)
+	missing := make([]string, 0)
+	for _, field := range fields {
+		f := v.FieldByName(field)
+		if !f.IsValid() {
+			return fmt.Errorf("unknown payment provider config field: %s", field)
+		}
+		if strings.TrimSpace(f.String()) == "" {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %q missing required config: %s", ErrProviderNotConfigured, cfg.Type, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ProviderFactory 支付提供商工厂函数
+// 根据配置创建支付提供商实例，缺少必填配置项时应返回包装了 ErrProviderNotConfigured 的错误
+type ProviderFactory func(cfg ProviderConfig) (PaymentProvider, error)
+
+// Registry 支付提供商注册表
+// 按类型名称注册工厂函数，并发安全
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]ProviderFactory
+}
+
+// NewRegistry 创建空的支付提供商注册表
+// 返回:
+//   - *Registry: 注册表实例
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: map[string]ProviderFactory{},
+	}
+}
+
+// Register 注册支付提供商工厂函数
+// 参数:
+//   - providerType: 提供商类型名称
+//   - factory: 工厂函数
+//
+// 返回:
+//   - error: 类型名称为空、工厂函数为空或重复注册时返回错误
+func (r *Registry) Register(providerType string, factory ProviderFactory) error {
+	if providerType == "" {
+		return errors.New("payment provider type is empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("payment provider %q factory is nil", providerType)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[providerType]; ok {
+		return fmt.Errorf("payment provider %q is already registered", providerType)
+	}
+	r.factories[providerType] = factory
+	return nil
+}
+
+// NewProvider 根据配置创建支付提供商实例
+// 参数:
+//   - cfg: 提供商配置，Type 决定使用的工厂函数，Disabled 为 true 时返回已禁用的提供商
+//
+// 返回:
+//   - PaymentProvider: 支付提供商实例
+//   - error: 类型未注册或配置无效时返回错误
+func (r *Registry) NewProvider(cfg ProviderConfig) (PaymentProvider, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[cfg.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider type: %q", cfg.Type)
+	}
+	if cfg.Disabled {
+		return NewDisabledPaymentProvider(cfg.Type, "disabled by config"), nil
+	}
+	return factory(cfg)
+}
+
+// Types 获取已注册的提供商类型名称
+// 返回:
+//   - []string: 按名称排序的类型名称列表
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.factories))
+	for providerType := range r.factories {
+		types = append(types, providerType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DefaultRegistry 默认注册表，已注册所有内置支付提供商
+var DefaultRegistry = newDefaultRegistry()
+
+// Register 向默认注册表注册支付提供商工厂函数
+// 参数:
+//   - providerType: 提供商类型名称
+//   - factory: 工厂函数
+//
+// 返回:
+//   - error: 错误信息
+func Register(providerType string, factory ProviderFactory) error {
+	return DefaultRegistry.Register(providerType, factory)
+}
+
+// NewProvider 使用默认注册表根据配置创建支付提供商实例
+// 参数:
+//   - cfg: 提供商配置
+//
+// 返回:
+//   - PaymentProvider: 支付提供商实例
+//   - error: 错误信息
+func NewProvider(cfg ProviderConfig) (PaymentProvider, error) {
+	return DefaultRegistry.NewProvider(cfg)
+}
+
+// asProvider 将具体提供商构造函数的返回值转换为接口
+// 构造失败时返回nil接口，避免出现包含nil指针的非nil接口值
+func asProvider[T PaymentProvider](provider T, err error) (PaymentProvider, error) {
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// newDefaultRegistry 创建注册了所有内置支付提供商的注册表
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	builtins := map[string]ProviderFactory{
+		ProviderTypeAlipay: func(cfg ProviderConfig) (PaymentProvider, error) {
+			return asProvider(NewAlipayPaymentProvider(cfg.AppId, cfg.AppCertificate, cfg.PrivateKey, cfg.AlipayPublicCert, cfg.AlipayRootCert, cfg.Environment))
+		},
+		ProviderTypeWechatPay: func(cfg ProviderConfig) (PaymentProvider, error) {
+			pp, err := NewWechatPaymentProvider(cfg.MchId, cfg.ApiV3Key, cfg.AppId, cfg.SerialNo, cfg.PrivateKey, cfg.Environment)
+			if err != nil {
+				return nil, err
+			}
+			pp.MiniProgramAppId = cfg.MiniProgramAppId
+			pp.MobileAppId = cfg.MobileAppId
+			return pp, nil
+		},
+		ProviderTypeStripe: func(cfg ProviderConfig) (PaymentProvider, error) {
+			return asProvider(NewStripePaymentProvider(cfg.PublishableKey, cfg.SecretKey, cfg.WebhookSecret, cfg.Environment))
+		},
+		ProviderTypePaypal: func(cfg ProviderConfig) (PaymentProvider, error) {
+			pp, err := NewPaypalPaymentProvider(cfg.ClientId, cfg.ClientSecret, cfg.WebhookId, cfg.Environment)
+			if err != nil {
+				return nil, err
+			}
+			pp.Experience = cfg.Experience
+			return pp, nil
+		},
+		ProviderTypeAirwallex: func(cfg ProviderConfig) (PaymentProvider, error) {
+			pp, err := NewAirwallexPaymentProvider(cfg.ClientId, cfg.ApiKey, cfg.WebhookSecret, cfg.Environment)
+			if err != nil {
+				return nil, err
+			}
+			pp.Checkout = cfg.AirwallexCheckout
+			return pp, nil
+		},
+		ProviderTypeGc: func(cfg ProviderConfig) (PaymentProvider, error) {
+			return asProvider(NewGcPaymentProvider(cfg.Xmpch, cfg.SecretKey, cfg.Host))
+		},
+		ProviderTypeBalance: func(cfg ProviderConfig) (PaymentProvider, error) {
+			return asProvider(NewBalancePaymentProvider())
+		},
+		ProviderTypeDummy: func(cfg ProviderConfig) (PaymentProvider, error) {
+			return asProvider(NewDummyPaymentProvider())
+		},
+	}
+	for providerType, factory := range builtins {
+		// 内置类型名称互不相同，注册不会失败
+		_ = r.Register(providerType, factory)
+	}
+	return r
+}
@@ -2,102 +2,165 @@
 package payment
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/stripe/stripe-go/v74"
-	stripeCheckout "github.com/stripe/stripe-go/v74/checkout/session"
-	stripeIntent "github.com/stripe/stripe-go/v74/paymentintent"
-	stripePrice "github.com/stripe/stripe-go/v74/price"
-	stripeProduct "github.com/stripe/stripe-go/v74/product"
+	"github.com/stripe/stripe-go/v74/client"
+	"github.com/stripe/stripe-go/v74/webhook"
 )
 
+// StripeSubscription Stripe订阅信息
+// 由订阅相关方法返回
+type StripeSubscription struct {
+	SubscriptionId     string            // 订阅ID
+	Status             string            // 订阅状态，例如 trialing、active、past_due、canceled
+	PaymentName        string            // 创建订阅的支付名称
+	ProductName        string            // 产品名称
+	ProductDisplayName string            // 产品显示名称
+	ProviderName       string            // 支付提供商名称
+	Price              Money             // 每个计费周期的金额（含货币类型）
+	Recurring          Recurring         // 计费周期，TrialDays 始终为0
+	Metadata           map[string]string // 商户自定义元数据
+	CustomerId         string            // Stripe客户ID
+	CancelAtPeriodEnd  bool              // 是否在当前计费周期结束时取消
+	Paused             bool              // 是否已暂停扣款
+	CurrentPeriodStart time.Time         // 当前计费周期开始时间
+	CurrentPeriodEnd   time.Time         // 当前计费周期结束时间
+	TrialEnd           time.Time         // 试用结束时间，未试用时为零值
+}
+
 // StripePaymentProvider Stripe支付提供商
 // 实现Stripe支付功能
 type StripePaymentProvider struct {
-	PublishableKey string // 可发布密钥
-	SecretKey      string // 秘密密钥
-	isProd         bool   // 是否为生产环境
+	Client         *client.API // Stripe客户端，每个实例独立持有密钥
+	PublishableKey string      // 可发布密钥
+	SecretKey      string      // 秘密密钥
+	WebhookSecret  string      // Webhook签名密钥
 }
 
 // NewStripePaymentProvider 创建新的Stripe支付提供商实例
 // 参数:
 //   - PublishableKey: Stripe可发布密钥
 //   - SecretKey: Stripe秘密密钥
+//   - WebhookSecret: Stripe Webhook签名密钥（whsec_开头），用于通知验签
+//   - env: 网关环境，需与密钥类型一致：正式环境使用live密钥，沙箱环境使用test密钥
 // 返回:
 //   - *StripePaymentProvider: Stripe支付提供商实例
-//   - error: 错误信息
-func NewStripePaymentProvider(PublishableKey, SecretKey string) (*StripePaymentProvider, error) {
-	isProd := true // 默认为生产环境
+//   - error: 错误信息，缺少必填参数时包装 ErrProviderNotConfigured
+func NewStripePaymentProvider(PublishableKey, SecretKey, WebhookSecret string, env Environment) (*StripePaymentProvider, error) {
+	err := checkRequiredParams(ProviderTypeStripe,
+		requiredParam{"SecretKey", SecretKey},
+		requiredParam{"WebhookSecret", WebhookSecret},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = env.Validate(); err != nil {
+		return nil, err
+	}
+	isProd := env.IsProd()
+
+	// Stripe通过密钥区分正式和测试模式，检查密钥与环境是否一致
+	if isProd && strings.Contains(SecretKey, "_test_") {
+		return nil, fmt.Errorf("stripe test mode secret key cannot be used in %s environment", EnvironmentProduction)
+	}
+	if !isProd && strings.Contains(SecretKey, "_live_") {
+		return nil, fmt.Errorf("stripe live mode secret key cannot be used in %s environment", EnvironmentSandbox)
+	}
 	
-	// 创建支付提供商实例
+	// 创建支付提供商实例，使用独立的客户端而非全局 stripe.Key，支持同一进程中的多个账户
 	pp := &StripePaymentProvider{
+		Client:         client.New(SecretKey, nil),
 		PublishableKey: PublishableKey,
 		SecretKey:      SecretKey,
-		isProd:         isProd,
+		WebhookSecret:  WebhookSecret,
 	}
-	
-	// 设置Stripe API密钥
-	stripe.Key = pp.SecretKey
 	return pp, nil
 }
 
 // Pay 执行Stripe支付操作
-// 创建产品、价格和结账会话
+// 按产品名称复用产品，使用内联价格创建结账会话
+// 支付请求携带订阅计费周期时使用周期价格创建订阅模式的结账会话
 // 参数:
+//   - ctx: 上下文
 //   - r: 支付请求信息
 // 返回:
 //   - *PayResp: 支付响应信息
 //   - error: 错误信息
-func (pp *StripePaymentProvider) Pay(r *PayReq) (*PayResp, error) {
-	// 创建临时产品
-	description := joinAttachString([]string{r.ProductName, r.ProductDisplayName, r.ProviderName})
-	productParams := &stripe.ProductParams{
-		Name:        stripe.String(r.ProductDisplayName),
-		Description: stripe.String(description),
-		DefaultPriceData: &stripe.ProductDefaultPriceDataParams{
-			UnitAmount: stripe.Int64(priceFloat64ToInt64(r.Price)),
-			Currency:   stripe.String(r.Currency),
-		},
-	}
-	sProduct, err := stripeProduct.New(productParams)
+func (pp *StripePaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	// 编码订单元数据，Stripe元数据值最长500个字符
+	metadata, err := encodeOrderMetadata(r, 500)
 	if err != nil {
 		return nil, err
 	}
-	
-	// 为现有产品创建价格
-	priceParams := &stripe.PriceParams{
-		Currency:   stripe.String(r.Currency),
-		UnitAmount: stripe.Int64(priceFloat64ToInt64(r.Price)),
-		Product:    stripe.String(sProduct.ID),
-	}
-	sPrice, err := stripePrice.New(priceParams)
+
+	// 获取或创建产品，同一产品名称的订单复用同一个Stripe产品
+	productId, err := pp.upsertProduct(ctx, r)
 	if err != nil {
 		return nil, err
 	}
+
+	// 订阅
+	if r.Recurring != nil {
+		return pp.paySubscription(ctx, r, productId, metadata)
+	}
+
+	// 使用内联价格，不在产品目录中为每笔订单创建价格
+	priceData := &stripe.CheckoutSessionLineItemPriceDataParams{
+		Currency:   stripe.String(strings.ToLower(r.Price.Currency)),
+		UnitAmount: stripe.Int64(r.Price.Amount),
+	}
+	if productId != "" {
+		priceData.Product = stripe.String(productId)
+	} else {
+		// 未指定产品名称时使用内联产品信息
+		priceData.ProductData = &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+			Name: stripe.String(r.ProductDisplayName),
+		}
+		if r.ProductDescription != "" {
+			priceData.ProductData.Description = stripe.String(r.ProductDescription)
+		}
+	}
 	
 	// 创建结账会话
 	checkoutParams := &stripe.CheckoutSessionParams{
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
-				Price:    stripe.String(sPrice.ID),
-				Quantity: stripe.Int64(1),
+				PriceData: priceData,
+				Quantity:  stripe.Int64(1),
 			},
 		},
 		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
 		SuccessURL:        stripe.String(r.ReturnUrl),
-		CancelURL:         stripe.String(r.ReturnUrl),
+		CancelURL:         stripe.String(r.getFailUrl()),
 		ClientReferenceID: stripe.String(r.PaymentName),
 		ExpiresAt:         stripe.Int64(time.Now().Add(30 * time.Minute).Unix()), // 30分钟后过期
+		// 支付名称保存到支付意图上，开具发票时按支付名称查找支付意图
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Description: stripe.String(r.ProductDisplayName),
+			Metadata: map[string]string{
+				"payment_name": r.PaymentName,
+			},
+		},
 	}
 	
-	// 添加产品描述元数据
-	checkoutParams.AddMetadata("product_description", description)
+	checkoutParams.Context = ctx
+
+	// 添加订单元数据
+	checkoutParams.AddMetadata("order_metadata", metadata)
 	
 	// 创建结账会话
-	sCheckout, err := stripeCheckout.New(checkoutParams)
+	sCheckout, err := pp.Client.CheckoutSessions.New(checkoutParams)
 	if err != nil {
-		return nil, err
+		return nil, wrapStripeError(err)
 	}
 	
 	// 构造支付响应
@@ -108,101 +171,1013 @@ func (pp *StripePaymentProvider) Pay(r *PayReq) (*PayResp, error) {
 	return payResp, nil
 }
 
+// paySubscription 创建订阅模式的结账会话
+// 买家完成结账后Stripe创建订阅，之后每个计费周期自动扣款
+// 参数:
+//   - ctx: 上下文
+//   - r: 支付请求信息
+//   - productId: 产品ID
+//   - metadata: 编码后的订单元数据
+// 返回:
+//   - *PayResp: 支付响应信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) paySubscription(ctx context.Context, r *PayReq, productId string, metadata string) (*PayResp, error) {
+	// 获取或创建周期价格
+	priceId, err := pp.getOrCreateRecurringPrice(ctx, r, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 订单元数据同时保存到订阅上，续费和取消通知中从订阅获取
+	subscriptionData := &stripe.CheckoutSessionSubscriptionDataParams{
+		Metadata: map[string]string{
+			"order_metadata": metadata,
+			"payment_name":   r.PaymentName,
+		},
+	}
+	if r.Recurring.TrialDays > 0 {
+		subscriptionData.TrialPeriodDays = stripe.Int64(r.Recurring.TrialDays)
+	}
+
+	// 创建结账会话
+	checkoutParams := &stripe.CheckoutSessionParams{
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(priceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:              stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		SuccessURL:        stripe.String(r.ReturnUrl),
+		CancelURL:         stripe.String(r.getFailUrl()),
+		ClientReferenceID: stripe.String(r.PaymentName),
+		ExpiresAt:         stripe.Int64(time.Now().Add(30 * time.Minute).Unix()), // 30分钟后过期
+		SubscriptionData:  subscriptionData,
+	}
+	checkoutParams.Context = ctx
+	checkoutParams.AddMetadata("order_metadata", metadata)
+
+	sCheckout, err := pp.Client.CheckoutSessions.New(checkoutParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+
+	// 构造支付响应
+	payResp := &PayResp{
+		PayUrl:  sCheckout.URL,
+		OrderId: sCheckout.ID,
+	}
+	return payResp, nil
+}
+
+// getOrCreateRecurringPrice 获取或创建周期价格
+// 使用由产品、金额和计费周期确定的 lookup_key 复用价格，不会为每笔订单创建价格
+// 参数:
+//   - ctx: 上下文
+//   - r: 支付请求信息，Recurring 不能为nil
+//   - productId: 产品ID
+// 返回:
+//   - string: 价格ID
+//   - error: 错误信息
+func (pp *StripePaymentProvider) getOrCreateRecurringPrice(ctx context.Context, r *PayReq, productId string) (string, error) {
+	if err := r.Recurring.Validate(); err != nil {
+		return "", err
+	}
+	if productId == "" {
+		return "", errors.New("stripe subscription requires product name")
+	}
+	intervalCount := r.Recurring.IntervalCount
+	if intervalCount == 0 {
+		intervalCount = 1
+	}
+	currency := strings.ToLower(r.Price.Currency)
+	lookupKey := fmt.Sprintf("%s_%s_%d_%s_%d", productId, currency, r.Price.Amount, r.Recurring.Interval, intervalCount)
+
+	// 查询已有价格
+	listParams := &stripe.PriceListParams{
+		Active:     stripe.Bool(true),
+		LookupKeys: stripe.StringSlice([]string{lookupKey}),
+	}
+	listParams.Context = ctx
+	iter := pp.Client.Prices.List(listParams)
+	if iter.Next() {
+		return iter.Price().ID, nil
+	}
+	if err := iter.Err(); err != nil {
+		return "", wrapStripeError(err)
+	}
+
+	// 创建价格
+	priceParams := &stripe.PriceParams{
+		Currency:   stripe.String(currency),
+		UnitAmount: stripe.Int64(r.Price.Amount),
+		Product:    stripe.String(productId),
+		LookupKey:  stripe.String(lookupKey),
+		Recurring: &stripe.PriceRecurringParams{
+			Interval:      stripe.String(r.Recurring.Interval),
+			IntervalCount: stripe.Int64(intervalCount),
+		},
+	}
+	// 并发创建同一价格时转移 lookup_key，避免因 lookup_key 重复而失败
+	priceParams.TransferLookupKey = stripe.Bool(true)
+	priceParams.Context = ctx
+	sPrice, err := pp.Client.Prices.New(priceParams)
+	if err != nil {
+		return "", wrapStripeError(err)
+	}
+	return sPrice.ID, nil
+}
+
+// upsertProduct 获取或创建产品名称对应的Stripe产品
+// 产品ID由产品名称确定，产品信息变化时同步更新
+// 参数:
+//   - ctx: 上下文
+//   - r: 支付请求信息
+// 返回:
+//   - string: 产品ID，产品名称为空时返回空字符串
+//   - error: 错误信息
+func (pp *StripePaymentProvider) upsertProduct(ctx context.Context, r *PayReq) (string, error) {
+	if r.ProductName == "" {
+		return "", nil
+	}
+	productId := getStripeProductId(r.ProductName)
+
+	// 产品显示名称为空时使用产品名称，Stripe产品名称不能为空
+	name := r.ProductDisplayName
+	if name == "" {
+		name = r.ProductName
+	}
+
+	// 查询已有产品
+	getParams := &stripe.ProductParams{}
+	getParams.Context = ctx
+	sProduct, err := pp.Client.Products.Get(productId, getParams)
+	if err != nil && !isStripeErrorCode(err, "resource_missing") {
+		return "", wrapStripeError(err)
+	}
+
+	// 产品不存在时创建
+	if err != nil {
+		productParams := &stripe.ProductParams{
+			ID:   stripe.String(productId),
+			Name: stripe.String(name),
+		}
+		if r.ProductDescription != "" {
+			productParams.Description = stripe.String(r.ProductDescription)
+		}
+		if r.ProductImage != "" {
+			productParams.Images = stripe.StringSlice([]string{r.ProductImage})
+		}
+		productParams.AddMetadata("product_name", r.ProductName)
+		productParams.Context = ctx
+		_, err = pp.Client.Products.New(productParams)
+		// 并发创建同一产品时，后创建的请求会失败，此时产品已存在
+		if err != nil && !isStripeErrorCode(err, "resource_already_exists") {
+			return "", wrapStripeError(err)
+		}
+		return productId, nil
+	}
+
+	// 产品信息变化或产品已归档时更新
+	changed := !sProduct.Active || sProduct.Name != name
+	if r.ProductDescription != "" && sProduct.Description != r.ProductDescription {
+		changed = true
+	}
+	if r.ProductImage != "" && (len(sProduct.Images) == 0 || sProduct.Images[0] != r.ProductImage) {
+		changed = true
+	}
+	if changed {
+		productParams := &stripe.ProductParams{
+			Active: stripe.Bool(true),
+			Name:   stripe.String(name),
+		}
+		if r.ProductDescription != "" {
+			productParams.Description = stripe.String(r.ProductDescription)
+		}
+		if r.ProductImage != "" {
+			productParams.Images = stripe.StringSlice([]string{r.ProductImage})
+		}
+		productParams.Context = ctx
+		if _, err = pp.Client.Products.Update(productId, productParams); err != nil {
+			return "", wrapStripeError(err)
+		}
+	}
+	return productId, nil
+}
+
 // Notify 处理Stripe支付通知
-// 查询结账会话和支付意图状态并返回通知结果
+// 校验 Stripe-Signature 签名，并根据 checkout.session.* 事件中的结账会话返回通知结果
+// 订阅的 invoice.paid 和 customer.subscription.deleted 事件以订阅ID作为订单ID校验
 // 参数:
+//   - ctx: 上下文
+//   - header: 通知请求头，包含 Stripe-Signature
 //   - body: 通知内容
-//   - orderId: 订单ID
+//   - orderId: 订单ID（结账会话ID）
 // 返回:
 //   - *NotifyResult: 通知结果
 //   - error: 错误信息
-func (pp *StripePaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	notifyResult := &NotifyResult{}
-	
-	// 获取结账会话信息
-	sCheckout, err := stripeCheckout.Get(orderId, nil)
+func (pp *StripePaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	// 校验签名并解析事件，事件API版本可能与SDK版本不一致
+	event, err := webhook.ConstructEventWithOptions(body, header.Get("Stripe-Signature"), pp.WebhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: stripe: %v", ErrInvalidNotifySignature, err)
+	}
+	if event.Data == nil {
+		return nil, fmt.Errorf("unsupported stripe event type: %v", event.Type)
+	}
+
+	// 订阅生命周期事件
+	switch event.Type {
+	case "invoice.paid":
+		return pp.notifyInvoicePaid(ctx, event.Data.Raw, orderId)
+	case "customer.subscription.deleted":
+		return pp.notifySubscriptionDeleted(ctx, event.Data.Raw, orderId)
+	}
+	if !strings.HasPrefix(string(event.Type), "checkout.session.") {
+		return nil, fmt.Errorf("unsupported stripe event type: %v", event.Type)
+	}
+
+	// 解析结账会话
+	sCheckout := &stripe.CheckoutSession{}
+	if err = json.Unmarshal(event.Data.Raw, sCheckout); err != nil {
+		return nil, err
+	}
+
+	// 校验订单ID
+	orderId, err = getNotifyOrderId(orderId, sCheckout.ID)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// 根据结账会话状态设置支付状态
-	switch sCheckout.Status {
-	case "open": // 结账会话仍在进行中，支付处理尚未开始
-		notifyResult.PaymentStatus = PaymentStateCreated
-		return notifyResult, nil
-	case "complete": // 结账会话已完成，支付处理可能仍在进行中
-		// 继续处理
-	case "expired": // 结账会话已过期，不会再进行处理
-		notifyResult.PaymentStatus = PaymentStateTimeout
-		return notifyResult, nil
-	default: // 未知状态
-		notifyResult.PaymentStatus = PaymentStateError
-		notifyResult.NotifyMessage = fmt.Sprintf("unexpected stripe checkout status: %v", sCheckout.Status)
-		return notifyResult, nil
+	notifyResult := &NotifyResult{
+		OrderId:     orderId,
+		PaymentName: sCheckout.ClientReferenceID,
 	}
-	
-	// 根据支付状态进一步判断
-	switch sCheckout.PaymentStatus {
-	case "paid": // 已支付
-		// 继续处理
-	case "unpaid": // 未支付
-		notifyResult.PaymentStatus = PaymentStateCreated
+	notifyResult.PaymentStatus = getStripePaymentState(sCheckout)
+	if notifyResult.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected stripe checkout status: %v, payment status: %v", sCheckout.Status, sCheckout.PaymentStatus)
 		return notifyResult, nil
-	default: // 未知支付状态
-		notifyResult.PaymentStatus = PaymentStateError
-		notifyResult.NotifyMessage = fmt.Sprintf("unexpected stripe checkout payment status: %v", sCheckout.PaymentStatus)
+	}
+	if notifyResult.PaymentStatus != PaymentStatePaid {
 		return notifyResult, nil
 	}
 	
-	// 支付成功后，结账会话将包含对成功的PaymentIntent的引用
-	sIntent, err := stripeIntent.Get(sCheckout.PaymentIntent.ID, nil)
+	// 构造通知结果
+	notifyResult = &NotifyResult{
+		PaymentName:   sCheckout.ClientReferenceID,
+		PaymentStatus: PaymentStatePaid,
+
+		Price: NewMoney(sCheckout.AmountTotal, string(sCheckout.Currency)),
+
+		OrderId: orderId,
+	}
+	if sCheckout.Subscription != nil {
+		notifyResult.SubscriptionId = sCheckout.Subscription.ID
+	}
+
+	// 解析订单元数据，旧订单的产品信息保存在 product_description 中
+	if metadata, ok := sCheckout.Metadata["order_metadata"]; ok {
+		applyOrderMetadata(notifyResult, metadata, legacyAttachNameFirst)
+	} else {
+		applyOrderMetadata(notifyResult, sCheckout.Metadata["product_description"], legacyAttachNameFirst)
+	}
+	return notifyResult, nil
+}
+
+// notifyInvoicePaid 处理订阅账单支付成功通知
+// 每个计费周期扣款成功时触发，通知结果的订单ID为账单ID；
+// 首期账单与 checkout.session.completed 对应同一笔扣款，订单ID为结账会话ID，避免重复履约
+// 参数:
+//   - ctx: 上下文
+//   - raw: 事件中的账单对象
+//   - orderId: Pay返回的结账会话ID或订阅ID，为空时不校验
+// 返回:
+//   - *NotifyResult: 通知结果
+//   - error: 错误信息
+func (pp *StripePaymentProvider) notifyInvoicePaid(ctx context.Context, raw json.RawMessage, orderId string) (*NotifyResult, error) {
+	sInvoice := &stripe.Invoice{}
+	if err := json.Unmarshal(raw, sInvoice); err != nil {
+		return nil, err
+	}
+	if sInvoice.Subscription == nil {
+		return nil, fmt.Errorf("stripe invoice %s has no subscription", sInvoice.ID)
+	}
+
+	// 订单元数据保存在订阅上
+	subscriptionParams := &stripe.SubscriptionParams{}
+	subscriptionParams.Context = ctx
+	sSubscription, err := pp.Client.Subscriptions.Get(sInvoice.Subscription.ID, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+
+	// 查找创建订阅的结账会话，用于校验订单ID和首期账单的订单ID
+	checkoutId, err := pp.getSubscriptionCheckoutId(ctx, sSubscription.ID)
 	if err != nil {
 		return nil, err
 	}
-	
-	// 解析产品信息
-	var (
-		productName        string
-		productDisplayName string
-		providerName       string
-	)
-	if description, ok := sCheckout.Metadata["product_description"]; ok {
-		productName, productDisplayName, providerName, _ = parseAttachString(description)
+	if err = checkSubscriptionOrderId(orderId, sSubscription.ID, checkoutId); err != nil {
+		return nil, err
 	}
-	
+	notifyOrderId := sInvoice.ID
+	if sInvoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate && checkoutId != "" {
+		notifyOrderId = checkoutId
+	}
+
 	// 构造通知结果
-	notifyResult = &NotifyResult{
-		PaymentName:   sCheckout.ClientReferenceID,
+	notifyResult := &NotifyResult{
+		PaymentName:   sSubscription.Metadata["payment_name"],
 		PaymentStatus: PaymentStatePaid,
 
-		ProductName:        productName,
-		ProductDisplayName: productDisplayName,
-		ProviderName:       providerName,
+		Price: NewMoney(sInvoice.AmountPaid, string(sInvoice.Currency)),
 
-		Price:    priceInt64ToFloat64(sIntent.Amount),
-		Currency: string(sIntent.Currency),
+		OrderId:        notifyOrderId,
+		SubscriptionId: sSubscription.ID,
+	}
+	applyOrderMetadata(notifyResult, sSubscription.Metadata["order_metadata"], legacyAttachNameFirst)
+	return notifyResult, nil
+}
 
-		OrderId: orderId,
+// notifySubscriptionDeleted 处理订阅取消通知
+// 订阅立即取消或在计费周期结束时取消后触发，通知结果的订单ID为订阅ID
+// 参数:
+//   - ctx: 上下文
+//   - raw: 事件中的订阅对象
+//   - orderId: Pay返回的结账会话ID或订阅ID，为空时不校验
+// 返回:
+//   - *NotifyResult: 通知结果
+//   - error: 错误信息
+func (pp *StripePaymentProvider) notifySubscriptionDeleted(ctx context.Context, raw json.RawMessage, orderId string) (*NotifyResult, error) {
+	sSubscription := &stripe.Subscription{}
+	if err := json.Unmarshal(raw, sSubscription); err != nil {
+		return nil, err
+	}
+	// 订单ID不是订阅ID时查找创建订阅的结账会话校验
+	if orderId != "" && orderId != sSubscription.ID {
+		checkoutId, err := pp.getSubscriptionCheckoutId(ctx, sSubscription.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkSubscriptionOrderId(orderId, sSubscription.ID, checkoutId); err != nil {
+			return nil, err
+		}
+	}
+
+	// 构造通知结果
+	subscription := toStripeSubscription(sSubscription)
+	notifyResult := &NotifyResult{
+		PaymentName:   subscription.PaymentName,
+		PaymentStatus: PaymentStateCanceled,
+
+		ProductName:        subscription.ProductName,
+		ProductDisplayName: subscription.ProductDisplayName,
+		ProviderName:       subscription.ProviderName,
+		Price:              subscription.Price,
+
+		Metadata: subscription.Metadata,
+
+		OrderId:        sSubscription.ID,
+		SubscriptionId: sSubscription.ID,
 	}
 	return notifyResult, nil
 }
 
+// getSubscriptionCheckoutId 查找创建订阅的结账会话ID
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+// 返回:
+//   - string: 结账会话ID，订阅不是通过结账会话创建时为空
+//   - error: 错误信息
+func (pp *StripePaymentProvider) getSubscriptionCheckoutId(ctx context.Context, subscriptionId string) (string, error) {
+	checkoutListParams := &stripe.CheckoutSessionListParams{
+		Subscription: stripe.String(subscriptionId),
+	}
+	checkoutListParams.Context = ctx
+	checkoutIter := pp.Client.CheckoutSessions.List(checkoutListParams)
+	if checkoutIter.Next() {
+		return checkoutIter.CheckoutSession().ID, nil
+	}
+	if err := checkoutIter.Err(); err != nil {
+		return "", wrapStripeError(err)
+	}
+	return "", nil
+}
+
+// checkSubscriptionOrderId 校验订阅通知对应的订单ID
+// 调用方保存的订单ID为Pay返回的结账会话ID，也可以传入订阅ID
+// 参数:
+//   - orderId: 调用方指定的订单ID，为空时不校验
+//   - subscriptionId: 通知中的订阅ID
+//   - checkoutId: 创建订阅的结账会话ID
+// 返回:
+//   - error: 订单ID与两者都不一致时返回错误
+func checkSubscriptionOrderId(orderId string, subscriptionId string, checkoutId string) error {
+	if orderId == "" || orderId == subscriptionId || (checkoutId != "" && orderId == checkoutId) {
+		return nil
+	}
+	return fmt.Errorf("notification order id mismatch: expected %s, got subscription %s", orderId, subscriptionId)
+}
+
+// QueryOrder 查询Stripe订单
+// 获取结账会话并展开关联的支付意图和最近一次扣款
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID（结账会话ID）
+// 返回:
+//   - *OrderResult: 订单查询结果
+//   - error: 错误信息
+func (pp *StripePaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	// 获取结账会话
+	checkoutParams := &stripe.CheckoutSessionParams{}
+	checkoutParams.Context = ctx
+	checkoutParams.AddExpand("payment_intent.latest_charge")
+	sCheckout, err := pp.Client.CheckoutSessions.Get(orderId, checkoutParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+
+	// 构造订单查询结果
+	orderResult := &OrderResult{
+		OrderId:       orderId,
+		PaymentName:   sCheckout.ClientReferenceID,
+		PaymentStatus: getStripePaymentState(sCheckout),
+		VendorState:   fmt.Sprintf("%s/%s", sCheckout.Status, sCheckout.PaymentStatus),
+		Price:         NewMoney(sCheckout.AmountTotal, string(sCheckout.Currency)),
+		CreateTime:    time.Unix(sCheckout.Created, 0),
+	}
+	if sCheckout.Subscription != nil {
+		orderResult.SubscriptionId = sCheckout.Subscription.ID
+	}
+
+	// 付款人信息
+	if sCheckout.Customer != nil {
+		orderResult.PayerId = sCheckout.Customer.ID
+	}
+	if sCheckout.CustomerDetails != nil {
+		orderResult.PayerName = sCheckout.CustomerDetails.Name
+		orderResult.PayerEmail = sCheckout.CustomerDetails.Email
+	}
+
+	// 支付意图在买家提交支付后才会创建
+	if intent := sCheckout.PaymentIntent; intent != nil {
+		orderResult.TransactionId = intent.ID
+		if intent.AmountReceived > 0 {
+			orderResult.PaidPrice = NewMoney(intent.AmountReceived, string(intent.Currency))
+		}
+		if intent.LatestCharge != nil && orderResult.PaymentStatus == PaymentStatePaid {
+			orderResult.PayTime = time.Unix(intent.LatestCharge.Created, 0)
+		}
+	}
+
+	// 解析订单元数据，旧订单的产品信息保存在 product_description 中
+	if metadata, ok := sCheckout.Metadata["order_metadata"]; ok {
+		applyOrderMetadataToOrder(orderResult, metadata, legacyAttachNameFirst)
+	} else {
+		applyOrderMetadataToOrder(orderResult, sCheckout.Metadata["product_description"], legacyAttachNameFirst)
+	}
+	return orderResult, nil
+}
+
+// CloseOrder 关闭Stripe订单
+// 使结账会话立即过期，只能关闭状态为 open 的结账会话
+// 关闭后订单查询结果为已超时，与结账会话自然过期一致
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID（结账会话ID）
+// 返回:
+//   - error: 错误信息
+func (pp *StripePaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 检查订单状态
+	orderResult, err := pp.QueryOrder(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	if ok, err := checkOrderClosable(ProviderTypeStripe, orderResult); !ok {
+		return err
+	}
+
+	// 使结账会话过期
+	expireParams := &stripe.CheckoutSessionExpireParams{}
+	expireParams.Context = ctx
+	if _, err = pp.Client.CheckoutSessions.Expire(orderId, expireParams); err != nil {
+		return wrapStripeError(err)
+	}
+	return nil
+}
+
 // GetInvoice 获取Stripe发票
-// 当前不支持发票功能，返回空字符串
+// 为已支付的订单开具发票：创建带有开票人信息的客户和发票，标记为线下已付款并定稿
+// 同一支付名称重复调用时返回已开具的发票，开具中断时继续处理之前的发票，开票信息修改后作废之前未完成的发票重新开具；
+// 订阅订单返回 Stripe 自动开具的最近一期账单
 // 参数:
-//   - paymentName: 支付名称
+//   - ctx: 上下文
+//   - paymentName: 支付名称；支付名称未随支付意图保存的旧订单可以传入订单ID（结账会话ID）
 //   - personName: 个人姓名
-//   - personIdCard: 身份证号
+//   - personIdCard: 身份证号，不会发送给Stripe
+//   - personEmail: 邮箱
+//   - personPhone: 电话
+//   - invoiceType: 发票类型，Organization 时以发票抬头作为客户名称
+//   - invoiceTitle: 发票抬头
+//   - invoiceTaxId: 税号，格式为"类型:税号"，例如 "eu_vat:DE123456789"，未指定类型时为 cn_tin
+// 返回:
+//   - string: 发票页面URL，不可用时为发票PDF的URL
+//   - error: 错误信息
+func (pp *StripePaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+	// 查找已开具的发票或已支付的支付意图
+	paidInvoice, intent, err := pp.getInvoicePayment(ctx, paymentName)
+	if err != nil {
+		return "", err
+	}
+	if paidInvoice != nil {
+		return getStripeInvoiceUrl(paidInvoice)
+	}
+
+	// 企业发票以发票抬头作为客户名称
+	customerName := personName
+	if invoiceType == "Organization" && invoiceTitle != "" {
+		customerName = invoiceTitle
+	}
+
+	// 幂等键包含开票信息摘要，修改开票信息后重试不会与之前的请求冲突
+	paramsHash := getStripeInvoiceParamsHash(customerName, personEmail, personPhone, invoiceTaxId, invoiceTitle)
+	keySuffix := paymentName + "_" + paramsHash
+
+	// 查找之前中断的开具过程留下的发票，幂等键过期后重试也不会重复开具
+	sInvoice, err := pp.getUnfinishedInvoice(ctx, paymentName, paramsHash)
+	if err != nil {
+		return "", err
+	}
+	if sInvoice == nil {
+		sInvoice, err = pp.createInvoice(ctx, paymentName, intent, customerName, personEmail, personPhone, invoiceTitle, invoiceTaxId, paramsHash, keySuffix)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// 添加发票项目，金额为实付金额；继续处理的草稿发票已有项目时跳过
+	if sInvoice.Status == "draft" && sInvoice.Total == 0 {
+		description := intent.Description
+		if description == "" {
+			description = paymentName
+		}
+		invoiceItemParams := &stripe.InvoiceItemParams{
+			Customer:    stripe.String(sInvoice.Customer.ID),
+			Invoice:     stripe.String(sInvoice.ID),
+			Amount:      stripe.Int64(intent.AmountReceived),
+			Currency:    stripe.String(string(intent.Currency)),
+			Description: stripe.String(description),
+		}
+		invoiceItemParams.Context = ctx
+		invoiceItemParams.SetIdempotencyKey("invoice_item_" + keySuffix)
+		if _, err = pp.Client.InvoiceItems.New(invoiceItemParams); err != nil {
+			return "", wrapStripeError(err)
+		}
+	}
+
+	// 定稿发票，关闭自动收款
+	if sInvoice.Status == "draft" {
+		finalizeParams := &stripe.InvoiceFinalizeInvoiceParams{
+			AutoAdvance: stripe.Bool(false),
+		}
+		finalizeParams.Context = ctx
+		finalizeParams.SetIdempotencyKey("invoice_finalize_" + keySuffix)
+		if _, err = pp.Client.Invoices.FinalizeInvoice(sInvoice.ID, finalizeParams); err != nil {
+			return "", wrapStripeError(err)
+		}
+	}
+
+	// 款项已通过结账会话支付，标记为线下已付款，不会再次扣款
+	payParams := &stripe.InvoicePayParams{
+		PaidOutOfBand: stripe.Bool(true),
+	}
+	payParams.Context = ctx
+	payParams.SetIdempotencyKey("invoice_pay_" + keySuffix)
+	sInvoice, err = pp.Client.Invoices.Pay(sInvoice.ID, payParams)
+	if err != nil {
+		return "", wrapStripeError(err)
+	}
+	return getStripeInvoiceUrl(sInvoice)
+}
+
+// createInvoice 为支付意图创建开票客户和草稿发票
+// 参数:
+//   - ctx: 上下文
+//   - paymentName: 支付名称
+//   - intent: 已支付的支付意图
+//   - customerName: 客户名称
 //   - personEmail: 邮箱
 //   - personPhone: 电话
-//   - invoiceType: 发票类型
 //   - invoiceTitle: 发票抬头
 //   - invoiceTaxId: 税号
+//   - paramsHash: 开票信息摘要，保存在发票元数据中
+//   - keySuffix: 幂等键后缀
 // 返回:
-//   - string: 发票信息（空）
+//   - *stripe.Invoice: 草稿发票
 //   - error: 错误信息
-func (pp *StripePaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
-	return "", nil
+func (pp *StripePaymentProvider) createInvoice(ctx context.Context, paymentName string, intent *stripe.PaymentIntent, customerName string, personEmail string, personPhone string, invoiceTitle string, invoiceTaxId string, paramsHash string, keySuffix string) (*stripe.Invoice, error) {
+	// 创建客户
+	customerParams := &stripe.CustomerParams{
+		Name: stripe.String(customerName),
+	}
+	if personEmail != "" {
+		customerParams.Email = stripe.String(personEmail)
+	}
+	if personPhone != "" {
+		customerParams.Phone = stripe.String(personPhone)
+	}
+	if invoiceTaxId != "" {
+		taxIdType, taxId := getStripeTaxId(invoiceTaxId)
+		customerParams.TaxIDData = []*stripe.CustomerTaxIDDataParams{
+			{
+				Type:  stripe.String(taxIdType),
+				Value: stripe.String(taxId),
+			},
+		}
+	}
+	if invoiceTitle != "" {
+		customerParams.AddMetadata("invoice_title", invoiceTitle)
+	}
+	customerParams.Context = ctx
+	customerParams.SetIdempotencyKey("invoice_customer_" + keySuffix)
+	sCustomer, err := pp.Client.Customers.New(customerParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+
+	// 创建发票，不包含客户其他待开票项目
+	invoiceParams := &stripe.InvoiceParams{
+		Customer:                    stripe.String(sCustomer.ID),
+		Currency:                    stripe.String(string(intent.Currency)),
+		AutoAdvance:                 stripe.Bool(false),
+		CollectionMethod:            stripe.String("charge_automatically"),
+		PendingInvoiceItemsBehavior: stripe.String("exclude"),
+	}
+	if invoiceTitle != "" {
+		invoiceParams.Description = stripe.String(invoiceTitle)
+	}
+	invoiceParams.AddMetadata("payment_name", paymentName)
+	invoiceParams.AddMetadata("payment_intent", intent.ID)
+	invoiceParams.AddMetadata("invoice_params", paramsHash)
+	invoiceParams.Context = ctx
+	invoiceParams.SetIdempotencyKey("invoice_" + keySuffix)
+	sInvoice, err := pp.Client.Invoices.New(invoiceParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return sInvoice, nil
+}
+
+// getUnfinishedInvoice 查找支付名称对应的未完成发票
+// 开具过程中断时会留下草稿或未付款的发票：开票信息一致时返回该发票继续处理；
+// 开票信息已修改时删除草稿或作废发票，由调用方按新的开票信息重新开具
+// 参数:
+//   - ctx: 上下文
+//   - paymentName: 支付名称
+//   - paramsHash: 本次开票信息的摘要
+// 返回:
+//   - *stripe.Invoice: 开票信息一致的未完成发票，没有时为nil
+//   - error: 错误信息
+func (pp *StripePaymentProvider) getUnfinishedInvoice(ctx context.Context, paymentName string, paramsHash string) (*stripe.Invoice, error) {
+	invoiceSearchParams := &stripe.InvoiceSearchParams{}
+	invoiceSearchParams.Context = ctx
+	invoiceSearchParams.Query = fmt.Sprintf("metadata['payment_name']:'%s'", escapeStripeSearchValue(paymentName))
+	invoiceIter := pp.Client.Invoices.Search(invoiceSearchParams)
+	var unfinished *stripe.Invoice
+	for invoiceIter.Next() {
+		sInvoice := invoiceIter.Invoice()
+		switch {
+		case sInvoice.Status != "draft" && sInvoice.Status != "open":
+			continue
+		case unfinished == nil && sInvoice.Metadata["invoice_params"] == paramsHash:
+			unfinished = sInvoice
+		case sInvoice.Status == "draft":
+			delParams := &stripe.InvoiceParams{}
+			delParams.Context = ctx
+			if _, err := pp.Client.Invoices.Del(sInvoice.ID, delParams); err != nil {
+				return nil, wrapStripeError(err)
+			}
+		default:
+			voidParams := &stripe.InvoiceVoidInvoiceParams{}
+			voidParams.Context = ctx
+			if _, err := pp.Client.Invoices.VoidInvoice(sInvoice.ID, voidParams); err != nil {
+				return nil, wrapStripeError(err)
+			}
+		}
+	}
+	if err := invoiceIter.Err(); err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return unfinished, nil
+}
+
+// getStripeInvoiceParamsHash 计算开票信息的摘要
+// 参数:
+//   - values: 开票信息
+// 返回:
+//   - string: 摘要
+func getStripeInvoiceParamsHash(values ...string) string {
+	h := sha256.New()
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// getInvoicePayment 查找支付名称对应的已支付账单或支付意图
+// 依次查找已开具的发票、订阅的最近一期账单、支付意图；均未找到且支付名称为结账会话ID时从结账会话中查找。
+// 搜索结果可能有约1分钟的延迟，此期间重复创建发票由幂等键保证
+// 参数:
+//   - ctx: 上下文
+//   - paymentName: 支付名称或结账会话ID
+// 返回:
+//   - *stripe.Invoice: 已支付的账单，不为nil时直接返回该账单
+//   - *stripe.PaymentIntent: 已支付的支付意图，需要为其开具发票
+//   - error: 错误信息，均未找到时错误码为 ErrorCodeNotFound
+func (pp *StripePaymentProvider) getInvoicePayment(ctx context.Context, paymentName string) (*stripe.Invoice, *stripe.PaymentIntent, error) {
+	query := fmt.Sprintf("metadata['payment_name']:'%s'", escapeStripeSearchValue(paymentName))
+
+	// 查询已开具的发票
+	invoiceSearchParams := &stripe.InvoiceSearchParams{}
+	invoiceSearchParams.Context = ctx
+	invoiceSearchParams.Query = query
+	invoiceIter := pp.Client.Invoices.Search(invoiceSearchParams)
+	for invoiceIter.Next() {
+		if sInvoice := invoiceIter.Invoice(); sInvoice.Status == "paid" {
+			return sInvoice, nil, nil
+		}
+	}
+	if err := invoiceIter.Err(); err != nil {
+		return nil, nil, wrapStripeError(err)
+	}
+
+	// 订阅订单的支付名称保存在订阅上，账单由 Stripe 自动开具
+	subscriptionSearchParams := &stripe.SubscriptionSearchParams{}
+	subscriptionSearchParams.Context = ctx
+	subscriptionSearchParams.Query = query
+	subscriptionSearchParams.AddExpand("data.latest_invoice")
+	subscriptionIter := pp.Client.Subscriptions.Search(subscriptionSearchParams)
+	for subscriptionIter.Next() {
+		if sInvoice := subscriptionIter.Subscription().LatestInvoice; sInvoice != nil && sInvoice.Status == "paid" {
+			return sInvoice, nil, nil
+		}
+	}
+	if err := subscriptionIter.Err(); err != nil {
+		return nil, nil, wrapStripeError(err)
+	}
+
+	// 按支付名称查找已支付的支付意图
+	intentSearchParams := &stripe.PaymentIntentSearchParams{}
+	intentSearchParams.Context = ctx
+	intentSearchParams.Query = query + " AND status:'succeeded'"
+	intentIter := pp.Client.PaymentIntents.Search(intentSearchParams)
+	if intentIter.Next() {
+		return nil, intentIter.PaymentIntent(), nil
+	}
+	if err := intentIter.Err(); err != nil {
+		return nil, nil, wrapStripeError(err)
+	}
+
+	// 旧订单的支付意图没有支付名称，从结账会话中查找
+	if strings.HasPrefix(paymentName, "cs_") {
+		checkoutParams := &stripe.CheckoutSessionParams{}
+		checkoutParams.Context = ctx
+		checkoutParams.AddExpand("payment_intent")
+		checkoutParams.AddExpand("invoice")
+		checkoutParams.AddExpand("subscription.latest_invoice")
+		sCheckout, err := pp.Client.CheckoutSessions.Get(paymentName, checkoutParams)
+		if err != nil && !isStripeErrorCode(err, "resource_missing") {
+			return nil, nil, wrapStripeError(err)
+		}
+		if sCheckout != nil {
+			if sCheckout.Invoice != nil && sCheckout.Invoice.Status == "paid" {
+				return sCheckout.Invoice, nil, nil
+			}
+			if sCheckout.Subscription != nil && sCheckout.Subscription.LatestInvoice != nil && sCheckout.Subscription.LatestInvoice.Status == "paid" {
+				return sCheckout.Subscription.LatestInvoice, nil, nil
+			}
+			if sCheckout.PaymentIntent != nil && sCheckout.PaymentIntent.Status == "succeeded" {
+				return nil, sCheckout.PaymentIntent, nil
+			}
+		}
+	}
+	return nil, nil, newError(ProviderTypeStripe, ErrorCodeNotFound, "", fmt.Sprintf("paid payment %s not found", paymentName))
+}
+
+// Refund 执行Stripe退款操作
+// 通过结账会话找到支付意图并对其发起退款
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+// 返回:
+//   - *RefundResp: 退款响应信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	idempotencyKey, err := getRefundRequestNo(ProviderTypeStripe, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取结账会话关联的支付意图
+	checkoutParams := &stripe.CheckoutSessionParams{}
+	checkoutParams.Context = ctx
+	sCheckout, err := pp.Client.CheckoutSessions.Get(r.OrderId, checkoutParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	if sCheckout.PaymentIntent == nil {
+		return nil, fmt.Errorf("stripe checkout session %s has no payment intent", r.OrderId)
+	}
+
+	// 设置退款参数，未指定金额时Stripe退还剩余金额
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(sCheckout.PaymentIntent.ID),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	refundParams.Context = ctx
+	if !r.Price.IsZero() {
+		refundParams.Amount = stripe.Int64(r.Price.Amount)
+	}
+	if r.Reason != "" {
+		refundParams.AddMetadata("reason", r.Reason)
+	}
+	refundParams.SetIdempotencyKey(idempotencyKey)
+
+	// 发起退款
+	sRefund, err := pp.Client.Refunds.New(refundParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+
+	// 根据退款状态设置结果
+	var refundState RefundState
+	switch sRefund.Status {
+	case stripe.RefundStatusSucceeded: // 退款成功
+		refundState = RefundStateSucceeded
+	case stripe.RefundStatusPending, stripe.RefundStatusRequiresAction: // 退款处理中
+		refundState = RefundStatePending
+	case stripe.RefundStatusCanceled: // 退款已取消
+		refundState = RefundStateCanceled
+	default: // 退款失败
+		refundState = RefundStateFailed
+	}
+
+	refundResp := &RefundResp{
+		RefundId:    sRefund.ID,
+		RefundState: refundState,
+		Price:       NewMoney(sRefund.Amount, string(sRefund.Currency)),
+	}
+	return refundResp, nil
+}
+
+// GetSubscription 获取Stripe订阅
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+// 返回:
+//   - *StripeSubscription: 订阅信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) GetSubscription(ctx context.Context, subscriptionId string) (*StripeSubscription, error) {
+	subscriptionParams := &stripe.SubscriptionParams{}
+	subscriptionParams.Context = ctx
+	sSubscription, err := pp.Client.Subscriptions.Get(subscriptionId, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return toStripeSubscription(sSubscription), nil
+}
+
+// CancelSubscription 取消Stripe订阅
+// 立即取消时不再扣款并触发 customer.subscription.deleted 通知；
+// 在计费周期结束时取消时，订阅在当前周期内保持有效，周期结束后再触发通知
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+//   - atPeriodEnd: 是否在当前计费周期结束时取消
+// 返回:
+//   - *StripeSubscription: 订阅信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) CancelSubscription(ctx context.Context, subscriptionId string, atPeriodEnd bool) (*StripeSubscription, error) {
+	var sSubscription *stripe.Subscription
+	var err error
+	if atPeriodEnd {
+		subscriptionParams := &stripe.SubscriptionParams{
+			CancelAtPeriodEnd: stripe.Bool(true),
+		}
+		subscriptionParams.Context = ctx
+		sSubscription, err = pp.Client.Subscriptions.Update(subscriptionId, subscriptionParams)
+	} else {
+		cancelParams := &stripe.SubscriptionCancelParams{}
+		cancelParams.Context = ctx
+		sSubscription, err = pp.Client.Subscriptions.Cancel(subscriptionId, cancelParams)
+	}
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return toStripeSubscription(sSubscription), nil
+}
+
+// PauseSubscription 暂停Stripe订阅扣款
+// 暂停期间订阅保持有效，生成的账单直接作废，不会向买家扣款
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+// 返回:
+//   - *StripeSubscription: 订阅信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) PauseSubscription(ctx context.Context, subscriptionId string) (*StripeSubscription, error) {
+	subscriptionParams := &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{
+			Behavior: stripe.String("void"),
+		},
+	}
+	subscriptionParams.Context = ctx
+	sSubscription, err := pp.Client.Subscriptions.Update(subscriptionId, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return toStripeSubscription(sSubscription), nil
+}
+
+// ResumeSubscription 恢复已暂停的Stripe订阅扣款
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+// 返回:
+//   - *StripeSubscription: 订阅信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) ResumeSubscription(ctx context.Context, subscriptionId string) (*StripeSubscription, error) {
+	subscriptionParams := &stripe.SubscriptionParams{}
+	subscriptionParams.Context = ctx
+	// 传空值清除 pause_collection
+	subscriptionParams.AddExtra("pause_collection", "")
+	sSubscription, err := pp.Client.Subscriptions.Update(subscriptionId, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return toStripeSubscription(sSubscription), nil
+}
+
+// ChangeSubscriptionPlan 变更Stripe订阅套餐
+// 按支付请求中的产品、金额和计费周期替换订阅价格，差额按比例计入下一期账单
+// 参数:
+//   - ctx: 上下文
+//   - subscriptionId: 订阅ID
+//   - r: 新套餐的支付请求信息，Recurring 不能为nil，TrialDays 被忽略
+// 返回:
+//   - *StripeSubscription: 订阅信息
+//   - error: 错误信息
+func (pp *StripePaymentProvider) ChangeSubscriptionPlan(ctx context.Context, subscriptionId string, r *PayReq) (*StripeSubscription, error) {
+	if r.Recurring == nil {
+		return nil, errors.New("stripe subscription plan requires recurring interval")
+	}
+	metadata, err := encodeOrderMetadata(r, 500)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取订阅当前的订阅项
+	subscriptionParams := &stripe.SubscriptionParams{}
+	subscriptionParams.Context = ctx
+	sSubscription, err := pp.Client.Subscriptions.Get(subscriptionId, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	if sSubscription.Items == nil || len(sSubscription.Items.Data) == 0 {
+		return nil, fmt.Errorf("stripe subscription %s has no items", subscriptionId)
+	}
+
+	// 获取新套餐的价格
+	productId, err := pp.upsertProduct(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	priceId, err := pp.getOrCreateRecurringPrice(ctx, r, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 替换订阅项的价格并更新订单元数据
+	subscriptionParams = &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(sSubscription.Items.Data[0].ID),
+				Price: stripe.String(priceId),
+			},
+		},
+		ProrationBehavior: stripe.String("create_prorations"),
+	}
+	subscriptionParams.Context = ctx
+	subscriptionParams.AddMetadata("order_metadata", metadata)
+	sSubscription, err = pp.Client.Subscriptions.Update(subscriptionId, subscriptionParams)
+	if err != nil {
+		return nil, wrapStripeError(err)
+	}
+	return toStripeSubscription(sSubscription), nil
 }
 
 // GetResponseError 获取Stripe响应错误信息
@@ -218,3 +1193,199 @@ func (pp *StripePaymentProvider) GetResponseError(err error) string {
 		return "fail" // 失败
 	}
 }
+
+// getStripePaymentState 根据结账会话状态和支付状态获取支付状态
+// 参数:
+//   - sCheckout: 结账会话
+//
+// 返回:
+//   - PaymentState: 支付状态，未知状态返回 PaymentStateError
+func getStripePaymentState(sCheckout *stripe.CheckoutSession) PaymentState {
+	switch sCheckout.Status {
+	case "open": // 结账会话仍在进行中，支付处理尚未开始
+		return PaymentStateCreated
+	case "complete": // 结账会话已完成，支付处理可能仍在进行中
+		// 根据支付状态进一步判断
+	case "expired": // 结账会话已过期，不会再进行处理
+		return PaymentStateTimeout
+	default: // 未知状态
+		return PaymentStateError
+	}
+
+	switch sCheckout.PaymentStatus {
+	case "paid": // 已支付
+		return PaymentStatePaid
+	case "unpaid": // 未支付
+		return PaymentStateCreated
+	case "no_payment_required": // 无需支付，例如订阅处于试用期
+		return PaymentStatePaid
+	default: // 未知支付状态
+		return PaymentStateError
+	}
+}
+
+// toStripeSubscription 将Stripe订阅转换为订阅信息
+// 参数:
+//   - sSubscription: Stripe订阅
+//
+// 返回:
+//   - *StripeSubscription: 订阅信息
+func toStripeSubscription(sSubscription *stripe.Subscription) *StripeSubscription {
+	subscription := &StripeSubscription{
+		SubscriptionId:     sSubscription.ID,
+		Status:             string(sSubscription.Status),
+		PaymentName:        sSubscription.Metadata["payment_name"],
+		CancelAtPeriodEnd:  sSubscription.CancelAtPeriodEnd,
+		Paused:             sSubscription.PauseCollection != nil,
+		CurrentPeriodStart: time.Unix(sSubscription.CurrentPeriodStart, 0),
+		CurrentPeriodEnd:   time.Unix(sSubscription.CurrentPeriodEnd, 0),
+	}
+	if sSubscription.TrialEnd > 0 {
+		subscription.TrialEnd = time.Unix(sSubscription.TrialEnd, 0)
+	}
+	if sSubscription.Customer != nil {
+		subscription.CustomerId = sSubscription.Customer.ID
+	}
+
+	// 订阅只包含一个订阅项
+	if sSubscription.Items != nil && len(sSubscription.Items.Data) > 0 {
+		if sPrice := sSubscription.Items.Data[0].Price; sPrice != nil {
+			subscription.Price = NewMoney(sPrice.UnitAmount, string(sPrice.Currency))
+			if sPrice.Recurring != nil {
+				subscription.Recurring = Recurring{
+					Interval:      string(sPrice.Recurring.Interval),
+					IntervalCount: sPrice.Recurring.IntervalCount,
+				}
+			}
+		}
+	}
+
+	// 解析订单元数据，元数据无法解码时仅保留空的产品信息
+	if m, err := decodeOrderMetadata(sSubscription.Metadata["order_metadata"], legacyAttachNameFirst); err == nil {
+		subscription.ProductName = m.ProductName
+		subscription.ProductDisplayName = m.ProductDisplayName
+		subscription.ProviderName = m.ProviderName
+		subscription.Metadata = m.Metadata
+	}
+	return subscription
+}
+
+// getStripeInvoiceUrl 获取Stripe发票的URL
+// 参数:
+//   - sInvoice: Stripe发票
+//
+// 返回:
+//   - string: 发票页面URL，不可用时为发票PDF的URL
+//   - error: 两者都为空时返回错误
+func getStripeInvoiceUrl(sInvoice *stripe.Invoice) (string, error) {
+	if sInvoice.HostedInvoiceURL != "" {
+		return sInvoice.HostedInvoiceURL, nil
+	}
+	if sInvoice.InvoicePDF != "" {
+		return sInvoice.InvoicePDF, nil
+	}
+	return "", fmt.Errorf("stripe invoice %s has no URL", sInvoice.ID)
+}
+
+// getStripeTaxId 解析税号
+// 参数:
+//   - invoiceTaxId: 税号，格式为"类型:税号"，未指定类型时为 cn_tin
+//
+// 返回:
+//   - string: Stripe税号类型
+//   - string: 税号
+func getStripeTaxId(invoiceTaxId string) (string, string) {
+	if taxIdType, taxId, ok := strings.Cut(invoiceTaxId, ":"); ok {
+		return taxIdType, taxId
+	}
+	return "cn_tin", invoiceTaxId
+}
+
+// escapeStripeSearchValue 转义Stripe搜索查询语句中的字符串值
+// 参数:
+//   - value: 字符串值
+//
+// 返回:
+//   - string: 转义后的字符串值
+func escapeStripeSearchValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, "'", `\'`)
+}
+
+// getStripeProductId 根据产品名称获取Stripe产品ID
+// 产品名称可能包含Stripe产品ID不允许的字符，因此使用其哈希值
+// 参数:
+//   - productName: 产品名称
+//
+// 返回:
+//   - string: 产品ID
+func getStripeProductId(productName string) string {
+	sum := sha256.Sum256([]byte(productName))
+	return "product_" + hex.EncodeToString(sum[:16])
+}
+
+// isStripeErrorCode 判断是否为指定错误码的Stripe错误
+// 参数:
+//   - err: Stripe SDK 返回的错误
+//   - code: Stripe错误码
+//
+// 返回:
+//   - bool: 是否匹配
+func isStripeErrorCode(err error, code string) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && string(stripeErr.Code) == code
+}
+
+// stripeErrorCodes Stripe错误码与支付错误码的映射
+var stripeErrorCodes = map[string]ErrorCode{
+	"insufficient_funds":              ErrorCodeInsufficientFunds,
+	"card_declined":                   ErrorCodeCardDeclined,
+	"expired_card":                    ErrorCodeCardDeclined,
+	"incorrect_cvc":                   ErrorCodeCardDeclined,
+	"processing_error":                ErrorCodeProviderUnavailable,
+	"resource_missing":                ErrorCodeNotFound,
+	"charge_already_refunded":         ErrorCodeDuplicateOrder,
+	"idempotency_key_in_use":          ErrorCodeDuplicateOrder,
+	"rate_limit":                      ErrorCodeRateLimited,
+	"lock_timeout":                    ErrorCodeRateLimited,
+	"api_key_expired":                 ErrorCodeAuthFailed,
+	"secret_key_required":             ErrorCodeAuthFailed,
+	"payment_intent_unexpected_state": ErrorCodeOrderClosed,
+}
+
+// wrapStripeError 将Stripe错误映射为支付错误
+// 参数:
+//   - err: Stripe SDK 返回的错误
+//
+// 返回:
+//   - error: 支付错误
+func wrapStripeError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return wrapError(ProviderTypeStripe, err)
+	}
+
+	// 优先使用拒付原因，其次使用错误码，最后按错误类型和HTTP状态码分类
+	vendorCode := string(stripeErr.Code)
+	code, ok := stripeErrorCodes[string(stripeErr.DeclineCode)]
+	if ok {
+		vendorCode = string(stripeErr.DeclineCode)
+	} else {
+		code, ok = stripeErrorCodes[vendorCode]
+	}
+	if !ok {
+		switch string(stripeErr.Type) {
+		case "card_error":
+			code = ErrorCodeCardDeclined
+		case "idempotency_error":
+			code = ErrorCodeDuplicateOrder
+		case "api_error":
+			code = ErrorCodeProviderUnavailable
+		default:
+			code = getErrorCodeByHTTPStatus(stripeErr.HTTPStatusCode)
+		}
+	}
+	e := newError(ProviderTypeStripe, code, vendorCode, stripeErr.Msg)
+	e.Err = err
+	return e
+}
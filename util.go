@@ -2,41 +2,19 @@
 package payment
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math"
 	"math/rand"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 	"unsafe"
 )
 
-// getPriceString 将价格浮点数转换为字符串
-// 去除末尾的零和小数点
-// 参数:
-//   - price: 价格浮点数
-//
-// 返回:
-//   - string: 格式化后的价格字符串
-func getPriceString(price float64) string {
-	priceString := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", price), "0"), ".")
-	return priceString
-}
-
-// joinAttachString 将字符串数组用分隔符连接
-// 参数:
-//   - tokens: 字符串数组
-//
-// 返回:
-//   - string: 用"|"分隔的字符串
-func joinAttachString(tokens []string) string {
-	return strings.Join(tokens, "|")
-}
-
-// parseAttachString 解析附加字符串
-// 将用"|"分隔的字符串解析为三个部分
+// parseAttachString 解析旧版附加字符串
+// 将用"|"分隔的字符串解析为三个部分，新订单使用 encodeOrderMetadata 编码
 // 参数:
 //   - s: 待解析的字符串
 //
@@ -53,51 +31,131 @@ func parseAttachString(s string) (string, string, string, error) {
 	return tokens[0], tokens[1], tokens[2], nil
 }
 
-// priceInt64ToFloat64 将整数价格转换为浮点数价格
-// 除以100将分转换为元
+// getRefundRequestNo 获取退款请求单号
+// 优先使用调用方提供的幂等键。部分退款必须提供幂等键，否则同一订单两次退款相同金额会被支付平台视为重复请求；
+// 全额退款每个订单只有一次，未提供幂等键时根据订单ID生成，重试同一全额退款不会重复退款
+// 参数:
+//   - provider: 提供商类型名称
+//   - r: 退款请求信息
+//
+// 返回:
+//   - string: 退款请求单号
+//   - error: 部分退款未提供幂等键时返回错误码为 ErrorCodeInvalidRequest 的 *Error
+func getRefundRequestNo(provider string, r RefundReq) (string, error) {
+	if r.IdempotencyKey != "" {
+		return r.IdempotencyKey, nil
+	}
+	if !r.Price.IsZero() {
+		return "", newError(provider, ErrorCodeInvalidRequest, "", "IdempotencyKey is required for partial refunds")
+	}
+	sum := sha256.Sum256([]byte(r.OrderId + "|full"))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// getRemainingRefundPrice 计算全额退款时的退款金额
+// 即订单金额减去调用方提供的已退款金额
 // 参数:
-//   - price: 整数价格（分）
+//   - provider: 提供商类型名称
+//   - total: 订单金额
+//   - refunded: 已退款金额
 //
 // 返回:
-//   - float64: 浮点数价格（元）
-func priceInt64ToFloat64(price int64) float64 {
-	return float64(price) / 100
+//   - Money: 退款金额
+//   - error: 货币不一致或订单已全额退款时返回错误码为 ErrorCodeInvalidRequest 的 *Error
+func getRemainingRefundPrice(provider string, total Money, refunded Money) (Money, error) {
+	if refunded.IsZero() {
+		return total, nil
+	}
+	if refunded.Currency != total.Currency {
+		return Money{}, newError(provider, ErrorCodeInvalidRequest, "", fmt.Sprintf("refunded currency %s does not match order currency %s", refunded.Currency, total.Currency))
+	}
+	if refunded.Amount >= total.Amount {
+		return Money{}, newError(provider, ErrorCodeInvalidRequest, "", fmt.Sprintf("order has been fully refunded: refunded %s of %s", refunded.String(), total.String()))
+	}
+	return NewMoney(total.Amount-refunded.Amount, total.Currency), nil
 }
 
-// priceFloat64ToInt64 将浮点数价格转换为整数价格
-// 乘以100将元转换为分
+// getNotifyOrderId 确定通知对应的订单ID
+// 调用方未指定订单ID时使用通知内容中的订单ID，两者不一致时返回错误
 // 参数:
-//   - price: 浮点数价格（元）
+//   - orderId: 调用方指定的订单ID
+//   - notifyOrderId: 已验签通知内容中的订单ID
 //
 // 返回:
-//   - int64: 整数价格（分）
-func priceFloat64ToInt64(price float64) int64 {
-	return int64(math.Round(price * 100))
+//   - string: 订单ID
+//   - error: 错误信息
+func getNotifyOrderId(orderId string, notifyOrderId string) (string, error) {
+	if orderId == "" {
+		orderId = notifyOrderId
+	}
+	if orderId == "" {
+		return "", errors.New("order id not found in notification")
+	}
+	if notifyOrderId != "" && notifyOrderId != orderId {
+		return "", fmt.Errorf("notification order id mismatch: expected %s, got %s", orderId, notifyOrderId)
+	}
+	return orderId, nil
+}
+
+// requiredParam 构造函数的必填参数
+type requiredParam struct {
+	name  string // 参数名称
+	value string // 参数值
 }
 
-// priceFloat64ToString 将浮点数价格转换为字符串
-// 保留两位小数
+// checkRequiredParams 校验构造函数的必填参数
 // 参数:
-//   - price: 浮点数价格
+//   - providerType: 提供商类型名称
+//   - params: 必填参数列表
 //
 // 返回:
-//   - string: 价格字符串
-func priceFloat64ToString(price float64) string {
-	return strconv.FormatFloat(price, 'f', 2, 64)
+//   - error: 缺少参数时返回包装了 ErrProviderNotConfigured 的错误，列出所有缺少的参数
+func checkRequiredParams(providerType string, params ...requiredParam) error {
+	missing := make([]string, 0)
+	for _, param := range params {
+		if strings.TrimSpace(param.value) == "" {
+			missing = append(missing, param.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s missing required parameters: %s", ErrProviderNotConfigured, providerType, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkOrderClosable 根据订单查询结果判断订单能否关闭
+// 已支付的订单不能关闭；已取消或已超时的订单无需再次关闭
+// 参数:
+//   - providerType: 提供商类型名称
+//   - orderResult: 订单查询结果
+//
+// 返回:
+//   - bool: 订单是否仍需关闭
+//   - error: 订单已支付时返回错误码为 ErrorCodeDuplicateOrder 的支付错误
+func checkOrderClosable(providerType string, orderResult *OrderResult) (bool, error) {
+	switch orderResult.PaymentStatus {
+	case PaymentStatePaid:
+		return false, newError(providerType, ErrorCodeDuplicateOrder, orderResult.VendorState, fmt.Sprintf("order %s is already paid", orderResult.OrderId))
+	case PaymentStateCanceled, PaymentStateTimeout:
+		return false, nil
+	default:
+		return true, nil
+	}
 }
 
-// priceStringToFloat64 将价格字符串转换为浮点数
+// checkRecurringNotSupported 校验支付请求不是订阅
+// 用于不支持订阅的提供商
 // 参数:
-//   - price: 价格字符串
+//   - providerType: 提供商类型名称
+//   - r: 支付请求信息
 //
 // 返回:
-//   - float64: 浮点数价格
-func priceStringToFloat64(price string) float64 {
-	f, err := strconv.ParseFloat(price, 64)
-	if err != nil {
-		panic(err)
+//   - error: 支付请求携带订阅计费周期时返回包装了 ErrNotSupported 的错误
+func checkRecurringNotSupported(providerType string, r *PayReq) error {
+	if r.Recurring != nil {
+		return fmt.Errorf("%w: %s does not support recurring payments", ErrNotSupported, providerType)
 	}
-	return f
+	return nil
 }
 
 func GetOwnerAndNameFromId(id string) (string, string) {
@@ -0,0 +1,118 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+// checkPaymentError 校验错误是否为指定错误码的支付错误
+// wantCode 为空时要求没有错误
+func checkPaymentError(t *testing.T, err error, wantCode ErrorCode) {
+	t.Helper()
+	if wantCode == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	var payErr *Error
+	if !errors.As(err, &payErr) {
+		t.Fatalf("error = %v, want *Error with code %s", err, wantCode)
+	}
+	if payErr.Code != wantCode {
+		t.Fatalf("error code = %s, want %s (%v)", payErr.Code, wantCode, err)
+	}
+}
+
+func TestGetRefundRequestNo(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      RefundReq
+		wantNo   string
+		wantCode ErrorCode
+	}{
+		{
+			name:   "idempotency key",
+			req:    RefundReq{OrderId: "order_1", Price: NewMoney(100, "CNY"), IdempotencyKey: "refund_1"},
+			wantNo: "refund_1",
+		},
+		{
+			name:     "partial refund without idempotency key",
+			req:      RefundReq{OrderId: "order_1", Price: NewMoney(100, "CNY")},
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name: "full refund without idempotency key",
+			req:  RefundReq{OrderId: "order_1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refundNo, err := getRefundRequestNo(ProviderTypeDummy, tt.req)
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode != "" {
+				return
+			}
+			if tt.wantNo != "" && refundNo != tt.wantNo {
+				t.Errorf("refund no = %q, want %q", refundNo, tt.wantNo)
+			}
+			if refundNo == "" {
+				t.Error("refund no is empty")
+			}
+		})
+	}
+
+	// 全额退款的请求号只由订单决定，重试时保持一致
+	first, _ := getRefundRequestNo(ProviderTypeDummy, RefundReq{OrderId: "order_1"})
+	retry, _ := getRefundRequestNo(ProviderTypeDummy, RefundReq{OrderId: "order_1", Reason: "retry"})
+	other, _ := getRefundRequestNo(ProviderTypeDummy, RefundReq{OrderId: "order_2"})
+	if first != retry {
+		t.Errorf("full refund retry changed the refund no: %q != %q", first, retry)
+	}
+	if first == other {
+		t.Errorf("different orders share the refund no %q", first)
+	}
+}
+
+func TestGetRemainingRefundPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    Money
+		refunded Money
+		want     Money
+		wantCode ErrorCode
+	}{
+		{
+			name:  "no earlier refund",
+			total: NewMoney(1000, "CNY"),
+			want:  NewMoney(1000, "CNY"),
+		},
+		{
+			name:     "after partial refund",
+			total:    NewMoney(1000, "CNY"),
+			refunded: NewMoney(300, "CNY"),
+			want:     NewMoney(700, "CNY"),
+		},
+		{
+			name:     "fully refunded",
+			total:    NewMoney(1000, "CNY"),
+			refunded: NewMoney(1000, "CNY"),
+			wantCode: ErrorCodeInvalidRequest,
+		},
+		{
+			name:     "currency mismatch",
+			total:    NewMoney(1000, "CNY"),
+			refunded: NewMoney(300, "USD"),
+			wantCode: ErrorCodeInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getRemainingRefundPrice(ProviderTypeAlipay, tt.total, tt.refunded)
+			checkPaymentError(t, err, tt.wantCode)
+			if tt.wantCode == "" && got != tt.want {
+				t.Errorf("remaining = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
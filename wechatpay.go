@@ -3,11 +3,18 @@ package payment
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/casdoor/casdoor/util"
 	"github.com/go-pay/gopay"
+	"github.com/go-pay/gopay/pkg/xpem"
 	"github.com/go-pay/gopay/wechat/v3"
 )
 
@@ -18,10 +25,16 @@ type WechatPayNotifyResponse struct {
 }
 
 // WechatPaymentProvider 微信支付提供商
-// 实现微信支付功能
+// 实现微信支付功能，创建后会启动后台协程定时刷新平台证书，不再使用时需调用 Close 停止
 type WechatPaymentProvider struct {
 	Client *wechat.ClientV3 // 微信支付客户端
-	AppId  string           // 应用ID
+	AppId  string           // 应用ID，公众号、H5和Native支付使用
+
+	MiniProgramAppId string // 小程序AppID，小程序支付使用，为空时使用AppId
+	MobileAppId      string // 移动应用AppID，App支付使用，为空时使用AppId
+
+	apiV3Key    string             // API v3密钥，用于解密通知内容
+	certManager *wechatCertManager // 平台证书管理器，用于通知验签
 }
 
 // NewWechatPaymentProvider 创建新的微信支付提供商实例
@@ -32,11 +45,12 @@ type WechatPaymentProvider struct {
 //   - appId: 应用ID
 //   - serialNo: 证书序列号
 //   - privateKey: 私钥
+//   - env: 网关环境，微信支付v3没有沙箱环境，只支持正式环境
 //
 // 返回:
 //   - *WechatPaymentProvider: 微信支付提供商实例
-//   - error: 错误信息
-func NewWechatPaymentProvider(mchId string, apiV3Key string, appId string, serialNo string, privateKey string) (*WechatPaymentProvider, error) {
+//   - error: 错误信息，缺少必填参数时包装 ErrProviderNotConfigured
+func NewWechatPaymentProvider(mchId string, apiV3Key string, appId string, serialNo string, privateKey string, env Environment) (*WechatPaymentProvider, error) {
 	// 参数映射说明:
 	// clientId => mchId
 	// clientSecret => apiV3Key
@@ -45,8 +59,23 @@ func NewWechatPaymentProvider(mchId string, apiV3Key string, appId string, seria
 	// appPrivateKey => privateKey
 
 	// 检查必要参数
-	if appId == "" || mchId == "" || serialNo == "" || apiV3Key == "" || privateKey == "" {
-		return &WechatPaymentProvider{}, nil
+	err := checkRequiredParams(ProviderTypeWechatPay,
+		requiredParam{"mchId", mchId},
+		requiredParam{"apiV3Key", apiV3Key},
+		requiredParam{"appId", appId},
+		requiredParam{"serialNo", serialNo},
+		requiredParam{"privateKey", privateKey},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查网关环境
+	if err = env.Validate(); err != nil {
+		return nil, err
+	}
+	if !env.IsProd() {
+		return nil, errors.New("wechat pay v3 has no sandbox environment")
 	}
 
 	// 创建微信支付客户端
@@ -55,165 +84,483 @@ func NewWechatPaymentProvider(mchId string, apiV3Key string, appId string, seria
 		return nil, err
 	}
 
-	// 获取平台证书
-	platformCert, serialNo, err := clientV3.GetAndSelectNewestCert()
-	if err != nil {
+	// 获取平台证书，之后由后台协程定时刷新
+	certManager := newWechatCertManager(mchId, serialNo, apiV3Key, privateKey)
+	if err = certManager.refresh(context.Background()); err != nil {
 		return nil, err
 	}
+	certManager.start()
+
+	// 客户端在共享前设置一次平台证书，之后不再修改，证书轮换只更新证书管理器
+	newestSerialNo, newestContent := certManager.getNewestCert()
+	clientV3.SetPlatformCert([]byte(newestContent), newestSerialNo)
 
 	// 创建支付提供商实例
 	pp := &WechatPaymentProvider{
-		Client: clientV3.SetPlatformCert([]byte(platformCert), serialNo),
-		AppId:  appId,
+		Client:      clientV3,
+		AppId:       appId,
+		apiV3Key:    apiV3Key,
+		certManager: certManager,
 	}
 
 	return pp, nil
 }
 
+// Close 停止定时刷新平台证书的后台协程
+// 可以重复调用，停止后处理通知时仍会按需刷新证书
+// 返回:
+//   - error: 错误信息，始终为nil
+func (pp *WechatPaymentProvider) Close() error {
+	if pp.certManager != nil {
+		pp.certManager.stop()
+	}
+	return nil
+}
+
+// RefreshPlatformCerts 立即刷新微信支付平台证书
+// 后台协程会定时自动刷新，通常无需调用，可用于监控证书下载是否正常
+// 参数:
+//   - ctx: 上下文
+//
+// 返回:
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) RefreshPlatformCerts(ctx context.Context) error {
+	if pp.certManager == nil {
+		return errors.New("wechat platform certs are not loaded")
+	}
+	return pp.certManager.refresh(ctx)
+}
+
 // Pay 执行微信支付操作
-// 根据支付环境选择JSAPI或Native支付方式
+// 根据支付环境选择JSAPI、小程序、H5、App或Native支付方式
 // 参数:
+//   - ctx: 上下文
 //   - r: 支付请求信息
 //
 // 返回:
 //   - *PayResp: 支付响应信息
 //   - error: 错误信息
-func (pp *WechatPaymentProvider) Pay(r *PayReq) (*PayResp, error) {
+func (pp *WechatPaymentProvider) Pay(ctx context.Context, r *PayReq) (*PayResp, error) {
+	if err := checkRecurringNotSupported(ProviderTypeWechatPay, r); err != nil {
+		return nil, err
+	}
+
 	bm := gopay.BodyMap{}
 
-	// 构造商品描述信息
-	desc := joinAttachString([]string{r.ProductDisplayName, r.ProductName, r.ProviderName})
+	// 编码订单元数据，附加数据最长128个字符
+	attach, err := encodeOrderMetadata(r, 128)
+	if err != nil {
+		return nil, err
+	}
 
 	// 设置基本支付参数
-	bm.Set("attach", desc)
-	bm.Set("appid", pp.AppId)
+	bm.Set("attach", attach)
 	bm.Set("description", r.ProductDisplayName)
 	bm.Set("notify_url", r.NotifyUrl)
 	bm.Set("out_trade_no", r.PaymentName)
 
 	// 设置金额信息
 	bm.SetBodyMap("amount", func(bm gopay.BodyMap) {
-		bm.Set("total", priceFloat64ToInt64(r.Price))
-		bm.Set("currency", r.Currency)
+		bm.Set("total", r.Price.Amount)
+		bm.Set("currency", r.Price.Currency)
 	})
 
-	// 在微信浏览器环境中使用JSAPI支付
-	if r.PaymentEnv == PaymentEnvWechatBrowser {
-		// 检查是否有付款人OpenID
-		if r.PayerId == "" {
-			return nil, errors.New("failed to get the payer's openid, please retry login")
-		}
+	// 根据支付环境选择支付方式
+	switch r.PaymentEnv {
+	case PaymentEnvWechatBrowser:
+		// 在微信浏览器环境中使用公众号JSAPI支付
+		return pp.payJsapi(ctx, bm, r, pp.AppId, false)
+	case PaymentEnvWechatMiniProgram:
+		// 在小程序中使用小程序AppID发起JSAPI支付
+		return pp.payJsapi(ctx, bm, r, pp.getMiniProgramAppId(), true)
+	case PaymentEnvMobileBrowser:
+		// 在微信外的手机浏览器中使用H5支付
+		return pp.payH5(ctx, bm, r)
+	case PaymentEnvApp:
+		// 在原生应用中使用App支付
+		return pp.payApp(ctx, bm, r)
+	default:
+		// 在其他情况下使用Native支付
+		return pp.payNative(ctx, bm, r)
+	}
+}
 
-		// 设置付款人信息
-		bm.SetBodyMap("payer", func(bm gopay.BodyMap) {
-			// 如果账户是通过微信注册的，PayerId就是微信OpenId，例如：oxW9O1ZDvgreSHuBSQDiQ2F055PI
-			bm.Set("openid", r.PayerId)
-		})
+// payJsapi 执行JSAPI支付，用于公众号和小程序
+// 参数:
+//   - ctx: 上下文
+//   - bm: 基本支付参数
+//   - r: 支付请求信息
+//   - appId: 公众号或小程序AppID，付款人OpenID必须属于该AppID
+//   - miniProgram: 是否为小程序支付
+//
+// 返回:
+//   - *PayResp: 支付响应信息，AttachInfo 包含调起支付所需的签名参数
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) payJsapi(ctx context.Context, bm gopay.BodyMap, r *PayReq, appId string, miniProgram bool) (*PayResp, error) {
+	// 检查是否有付款人OpenID
+	if r.PayerId == "" {
+		return nil, newError(ProviderTypeWechatPay, ErrorCodeInvalidRequest, "", "failed to get the payer's openid, please retry login")
+	}
 
-		// 调用JSAPI支付接口
-		jsapiRsp, err := pp.Client.V3TransactionJsapi(context.Background(), bm)
-		if err != nil {
-			return nil, err
-		}
-		if jsapiRsp.Code != wechat.Success {
-			return nil, errors.New(jsapiRsp.Error)
-		}
+	// 设置付款人信息
+	bm.Set("appid", appId)
+	bm.SetBodyMap("payer", func(bm gopay.BodyMap) {
+		// 如果账户是通过微信注册的，PayerId就是微信OpenId，例如：oxW9O1ZDvgreSHuBSQDiQ2F055PI
+		bm.Set("openid", r.PayerId)
+	})
+
+	// 调用JSAPI支付接口
+	jsapiRsp, err := pp.Client.V3TransactionJsapi(ctx, bm)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if jsapiRsp.Code != wechat.Success {
+		return nil, wrapWechatError(jsapiRsp.Code, jsapiRsp.Error)
+	}
 
-		// 使用RSA256签名支付请求
-		params, err := pp.Client.PaySignOfJSAPI(pp.AppId, jsapiRsp.Response.PrepayId)
+	// 使用RSA256签名支付请求，公众号和小程序的签名参数格式相同
+	var attachInfo map[string]interface{}
+	if miniProgram {
+		params, err := pp.Client.PaySignOfApplet(appId, jsapiRsp.Response.PrepayId)
 		if err != nil {
 			return nil, err
 		}
-
-		// 构造JSAPI支付响应
-		payResp := &PayResp{
-			PayUrl:  "",
-			OrderId: r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
-			AttachInfo: map[string]interface{}{
-				"appId":     params.AppId,
-				"timeStamp": params.TimeStamp,
-				"nonceStr":  params.NonceStr,
-				"package":   params.Package,
-				"signType":  "RSA",
-				"paySign":   params.PaySign,
-			},
+		attachInfo = map[string]interface{}{
+			"appId":     params.AppId,
+			"timeStamp": params.TimeStamp,
+			"nonceStr":  params.NonceStr,
+			"package":   params.Package,
+			"signType":  "RSA",
+			"paySign":   params.PaySign,
 		}
-		return payResp, nil
 	} else {
-		// 在其他情况下使用Native支付
-		nativeRsp, err := pp.Client.V3TransactionNative(context.Background(), bm)
+		params, err := pp.Client.PaySignOfJSAPI(appId, jsapiRsp.Response.PrepayId)
 		if err != nil {
 			return nil, err
 		}
-		if nativeRsp.Code != wechat.Success {
-			return nil, errors.New(nativeRsp.Error)
+		attachInfo = map[string]interface{}{
+			"appId":     params.AppId,
+			"timeStamp": params.TimeStamp,
+			"nonceStr":  params.NonceStr,
+			"package":   params.Package,
+			"signType":  "RSA",
+			"paySign":   params.PaySign,
 		}
+	}
 
-		// 构造Native支付响应
-		payResp := &PayResp{
-			PayUrl:  nativeRsp.Response.CodeUrl,
-			OrderId: r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
-		}
-		return payResp, nil
+	// 构造JSAPI支付响应
+	payResp := &PayResp{
+		PayUrl:     "",
+		OrderId:    r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
+		AttachInfo: attachInfo,
+	}
+	return payResp, nil
+}
+
+// payH5 执行H5支付，用于微信外的手机浏览器
+// 参数:
+//   - ctx: 上下文
+//   - bm: 基本支付参数
+//   - r: 支付请求信息，ClientIp 必填
+//
+// 返回:
+//   - *PayResp: 支付响应信息，PayUrl 为拉起微信支付的中间页地址，有效期5分钟
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) payH5(ctx context.Context, bm gopay.BodyMap, r *PayReq) (*PayResp, error) {
+	// 检查付款人客户端IP
+	if r.ClientIp == "" {
+		return nil, newError(ProviderTypeWechatPay, ErrorCodeInvalidRequest, "", "payer client ip is required for wechat h5 payment")
+	}
+
+	// 设置场景信息
+	bm.Set("appid", pp.AppId)
+	bm.SetBodyMap("scene_info", func(bm gopay.BodyMap) {
+		bm.Set("payer_client_ip", r.ClientIp)
+		bm.SetBodyMap("h5_info", func(bm gopay.BodyMap) {
+			bm.Set("type", "Wap")
+		})
+	})
+
+	// 调用H5支付接口
+	h5Rsp, err := pp.Client.V3TransactionH5(ctx, bm)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if h5Rsp.Code != wechat.Success {
+		return nil, wrapWechatError(h5Rsp.Code, h5Rsp.Error)
+	}
+
+	// 支付完成或取消后返回商户页面
+	payUrl := h5Rsp.Response.H5Url
+	if r.ReturnUrl != "" {
+		payUrl += "&redirect_url=" + url.QueryEscape(r.ReturnUrl)
+	}
+
+	// 构造H5支付响应
+	payResp := &PayResp{
+		PayUrl:  payUrl,
+		OrderId: r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
+	}
+	return payResp, nil
+}
+
+// payApp 执行App支付，用于原生应用
+// 参数:
+//   - ctx: 上下文
+//   - bm: 基本支付参数
+//   - r: 支付请求信息
+//
+// 返回:
+//   - *PayResp: 支付响应信息，AttachInfo 包含客户端SDK调起支付所需的签名参数
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) payApp(ctx context.Context, bm gopay.BodyMap, r *PayReq) (*PayResp, error) {
+	appId := pp.getMobileAppId()
+	bm.Set("appid", appId)
+
+	// 调用App支付接口
+	appRsp, err := pp.Client.V3TransactionApp(ctx, bm)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if appRsp.Code != wechat.Success {
+		return nil, wrapWechatError(appRsp.Code, appRsp.Error)
+	}
+
+	// 使用RSA256签名支付请求
+	params, err := pp.Client.PaySignOfApp(appId, appRsp.Response.PrepayId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 构造App支付响应
+	payResp := &PayResp{
+		PayUrl:  "",
+		OrderId: r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
+		AttachInfo: map[string]interface{}{
+			"appid":     params.Appid,
+			"partnerid": params.Partnerid,
+			"prepayid":  params.Prepayid,
+			"package":   params.Package,
+			"noncestr":  params.Noncestr,
+			"timestamp": params.Timestamp,
+			"sign":      params.Sign,
+		},
 	}
+	return payResp, nil
+}
+
+// payNative 执行Native支付，返回二维码链接
+// 参数:
+//   - ctx: 上下文
+//   - bm: 基本支付参数
+//   - r: 支付请求信息
+//
+// 返回:
+//   - *PayResp: 支付响应信息，PayUrl 为二维码链接
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) payNative(ctx context.Context, bm gopay.BodyMap, r *PayReq) (*PayResp, error) {
+	bm.Set("appid", pp.AppId)
+
+	// 调用Native支付接口
+	nativeRsp, err := pp.Client.V3TransactionNative(ctx, bm)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if nativeRsp.Code != wechat.Success {
+		return nil, wrapWechatError(nativeRsp.Code, nativeRsp.Error)
+	}
+
+	// 构造Native支付响应
+	payResp := &PayResp{
+		PayUrl:  nativeRsp.Response.CodeUrl,
+		OrderId: r.PaymentName, // 微信可以使用paymentName作为OutTradeNo来查询订单状态
+	}
+	return payResp, nil
+}
+
+// getMiniProgramAppId 获取小程序支付使用的AppID
+// 返回:
+//   - string: 小程序AppID，未配置时使用AppId
+func (pp *WechatPaymentProvider) getMiniProgramAppId() string {
+	if pp.MiniProgramAppId != "" {
+		return pp.MiniProgramAppId
+	}
+	return pp.AppId
+}
+
+// getMobileAppId 获取App支付使用的AppID
+// 返回:
+//   - string: 移动应用AppID，未配置时使用AppId
+func (pp *WechatPaymentProvider) getMobileAppId() string {
+	if pp.MobileAppId != "" {
+		return pp.MobileAppId
+	}
+	return pp.AppId
 }
 
 // Notify 处理微信支付通知
-// 查询订单状态并返回通知结果
+// 使用平台证书校验 Wechatpay-Signature 签名，解密通知资源并返回通知结果
 // 参数:
+//   - ctx: 上下文
+//   - header: 通知请求头，包含 Wechatpay-Timestamp、Wechatpay-Nonce、Wechatpay-Signature、Wechatpay-Serial
 //   - body: 通知内容
 //   - orderId: 订单ID
 //
 // 返回:
 //   - *NotifyResult: 通知结果
 //   - error: 错误信息
-func (pp *WechatPaymentProvider) Notify(body []byte, orderId string) (*NotifyResult, error) {
-	notifyResult := &NotifyResult{}
+func (pp *WechatPaymentProvider) Notify(ctx context.Context, header http.Header, body []byte, orderId string) (*NotifyResult, error) {
+	// 解析通知内容
+	notifyReq := &wechat.V3NotifyReq{
+		SignInfo: &wechat.SignInfo{
+			HeaderTimestamp: header.Get(wechat.HeaderTimestamp),
+			HeaderNonce:     header.Get(wechat.HeaderNonce),
+			HeaderSignature: header.Get(wechat.HeaderSignature),
+			HeaderSerial:    header.Get(wechat.HeaderSerial),
+			SignBody:        string(body),
+		},
+	}
+	if err := json.Unmarshal(body, notifyReq); err != nil {
+		return nil, err
+	}
+
+	// 根据 Wechatpay-Serial 选择平台证书并校验签名，证书轮换期间新旧证书均有效
+	publicKey, err := pp.certManager.getPublicKey(ctx, notifyReq.SignInfo.HeaderSerial)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wechat: %v", ErrInvalidNotifySignature, err)
+	}
+	if err := notifyReq.VerifySignByPK(publicKey); err != nil {
+		return nil, fmt.Errorf("%w: wechat: %v", ErrInvalidNotifySignature, err)
+	}
 
-	// 查询订单状态
-	queryRsp, err := pp.Client.V3TransactionQueryOrder(context.Background(), wechat.OutTradeNo, orderId)
+	// 使用API v3密钥解密通知资源
+	result, err := notifyReq.DecryptCipherText(pp.apiV3Key)
 	if err != nil {
 		return nil, err
 	}
-	if queryRsp.Code != wechat.Success {
-		return nil, errors.New(queryRsp.Error)
+
+	// 校验订单ID
+	orderId, err = getNotifyOrderId(orderId, result.OutTradeNo)
+	if err != nil {
+		return nil, err
 	}
 
 	// 根据交易状态设置支付状态
-	switch queryRsp.Response.TradeState {
-	case "SUCCESS": // 支付成功
-		// 继续处理
-	case "CLOSED": // 已关闭
-		notifyResult.PaymentStatus = PaymentStateCanceled
-		return notifyResult, nil
-	case "NOTPAY", "USERPAYING": // 未支付：等待用户支付；用户支付中：用户正在支付
-		notifyResult.PaymentStatus = PaymentStateCreated
+	notifyResult := &NotifyResult{
+		OrderId:     orderId,
+		PaymentName: result.OutTradeNo,
+	}
+	notifyResult.PaymentStatus = getWechatPaymentState(result.TradeState)
+	if notifyResult.PaymentStatus == PaymentStateError {
+		notifyResult.NotifyMessage = fmt.Sprintf("unexpected wechat trade state: %v", result.TradeState)
 		return notifyResult, nil
-	default: // 未知状态
-		notifyResult.PaymentStatus = PaymentStateError
-		notifyResult.NotifyMessage = fmt.Sprintf("unexpected wechat trade state: %v", queryRsp.Response.TradeState)
+	}
+	if notifyResult.PaymentStatus != PaymentStatePaid {
 		return notifyResult, nil
 	}
-
-	// 解析产品信息
-	productDisplayName, productName, providerName, _ := parseAttachString(queryRsp.Response.Attach)
+	if result.Amount == nil {
+		return nil, fmt.Errorf("wechat notification for order %s has no amount", orderId)
+	}
 
 	// 构造通知结果
 	notifyResult = &NotifyResult{
-		ProductName:        productName,
-		ProductDisplayName: productDisplayName,
-		ProviderName:       providerName,
-		OrderId:            orderId,
-		Price:              priceInt64ToFloat64(int64(queryRsp.Response.Amount.Total)),
-		PaymentStatus:      PaymentStatePaid,
-		PaymentName:        queryRsp.Response.OutTradeNo,
+		OrderId:       orderId,
+		Price:         NewMoney(int64(result.Amount.Total), result.Amount.Currency),
+		PaymentStatus: PaymentStatePaid,
+		PaymentName:   result.OutTradeNo,
 	}
+
+	// 解析订单元数据
+	applyOrderMetadata(notifyResult, result.Attach, legacyAttachDisplayFirst)
 	return notifyResult, nil
 }
 
+// QueryOrder 查询微信支付订单
+// 通过商户订单号查询订单，附加数据在查询结果中原样返回
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+//
+// 返回:
+//   - *OrderResult: 订单查询结果
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	// 查询订单
+	queryRsp, err := pp.Client.V3TransactionQueryOrder(ctx, wechat.OutTradeNo, orderId)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if queryRsp.Code != wechat.Success {
+		return nil, wrapWechatError(queryRsp.Code, queryRsp.Error)
+	}
+	order := queryRsp.Response
+
+	// 构造订单查询结果
+	orderResult := &OrderResult{
+		OrderId:       orderId,
+		PaymentName:   order.OutTradeNo,
+		PaymentStatus: getWechatPaymentState(order.TradeState),
+		VendorState:   order.TradeState,
+		TransactionId: order.TransactionId,
+	}
+	if order.Payer != nil {
+		orderResult.PayerId = order.Payer.Openid
+	}
+
+	// 订单金额信息仅在支付成功时返回
+	if order.Amount != nil {
+		orderResult.Price = NewMoney(int64(order.Amount.Total), order.Amount.Currency)
+		if order.Amount.PayerTotal > 0 {
+			orderResult.PaidPrice = NewMoney(int64(order.Amount.PayerTotal), order.Amount.PayerCurrency)
+		}
+	}
+
+	// 支付完成时间为RFC3339格式
+	if order.SuccessTime != "" {
+		orderResult.PayTime, err = time.Parse(time.RFC3339, order.SuccessTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 解析订单元数据
+	applyOrderMetadataToOrder(orderResult, order.Attach, legacyAttachDisplayFirst)
+	return orderResult, nil
+}
+
+// CloseOrder 关闭微信支付订单
+// 关闭后Native支付二维码和JSAPI预支付交易将无法继续支付
+// 参数:
+//   - ctx: 上下文
+//   - orderId: 订单ID
+//
+// 返回:
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) CloseOrder(ctx context.Context, orderId string) error {
+	// 检查订单状态
+	orderResult, err := pp.QueryOrder(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	if ok, err := checkOrderClosable(ProviderTypeWechatPay, orderResult); !ok {
+		return err
+	}
+
+	// 关闭订单
+	closeRsp, err := pp.Client.V3TransactionCloseOrder(ctx, orderId)
+	if err != nil {
+		return wrapError(ProviderTypeWechatPay, err)
+	}
+	if closeRsp.Code != wechat.Success {
+		return wrapWechatError(closeRsp.Code, closeRsp.Error)
+	}
+	return nil
+}
+
 // GetInvoice 获取微信支付发票
 // 当前不支持发票功能，返回空字符串
 // 参数:
+//   - ctx: 上下文
 //   - paymentName: 支付名称
 //   - personName: 个人姓名
 //   - personIdCard: 身份证号
@@ -226,10 +573,92 @@ func (pp *WechatPaymentProvider) Notify(body []byte, orderId string) (*NotifyRes
 // 返回:
 //   - string: 发票信息（空）
 //   - error: 错误信息
-func (pp *WechatPaymentProvider) GetInvoice(paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
+func (pp *WechatPaymentProvider) GetInvoice(ctx context.Context, paymentName string, personName string, personIdCard string, personEmail string, personPhone string, invoiceType string, invoiceTitle string, invoiceTaxId string) (string, error) {
 	return "", nil
 }
 
+// Refund 执行微信支付退款操作
+// 微信退款需要原订单金额，因此先查询订单再发起退款
+// 参数:
+//   - ctx: 上下文
+//   - r: 退款请求信息
+//
+// 返回:
+//   - *RefundResp: 退款响应信息
+//   - error: 错误信息
+func (pp *WechatPaymentProvider) Refund(ctx context.Context, r RefundReq) (*RefundResp, error) {
+	refundRequestNo, err := getRefundRequestNo(ProviderTypeWechatPay, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 查询原订单金额
+	queryRsp, err := pp.Client.V3TransactionQueryOrder(ctx, wechat.OutTradeNo, r.OrderId)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if queryRsp.Code != wechat.Success {
+		return nil, wrapWechatError(queryRsp.Code, queryRsp.Error)
+	}
+	if queryRsp.Response.Amount == nil {
+		return nil, fmt.Errorf("wechat order %s has no paid amount", r.OrderId)
+	}
+	total := int64(queryRsp.Response.Amount.Total)
+	currency := queryRsp.Response.Amount.Currency
+
+	// 未指定退款金额时全额退款，扣除已退款金额
+	refund := r.Price.Amount
+	if r.Price.IsZero() {
+		remaining, err := getRemainingRefundPrice(ProviderTypeWechatPay, NewMoney(total, currency), r.RefundedPrice)
+		if err != nil {
+			return nil, err
+		}
+		refund = remaining.Amount
+	}
+
+	// 设置退款参数，out_refund_no 用于幂等控制
+	bm := gopay.BodyMap{}
+	bm.Set("out_trade_no", r.OrderId)
+	bm.Set("out_refund_no", refundRequestNo)
+	if r.Reason != "" {
+		bm.Set("reason", r.Reason)
+	}
+	bm.SetBodyMap("amount", func(bm gopay.BodyMap) {
+		bm.Set("refund", refund)
+		bm.Set("total", total)
+		bm.Set("currency", currency)
+	})
+
+	// 发起退款
+	refundRsp, err := pp.Client.V3Refund(ctx, bm)
+	if err != nil {
+		return nil, wrapError(ProviderTypeWechatPay, err)
+	}
+	if refundRsp.Code != wechat.Success {
+		return nil, wrapWechatError(refundRsp.Code, refundRsp.Error)
+	}
+
+	// 根据退款状态设置结果
+	var refundState RefundState
+	switch refundRsp.Response.Status {
+	case "SUCCESS": // 退款成功
+		refundState = RefundStateSucceeded
+	case "PROCESSING": // 退款处理中
+		refundState = RefundStatePending
+	case "CLOSED": // 退款关闭
+		refundState = RefundStateCanceled
+	default: // 退款异常
+		refundState = RefundStateFailed
+	}
+
+	refundResp := &RefundResp{
+		RefundId:    refundRsp.Response.RefundId,
+		RefundState: refundState,
+		Price:       NewMoney(refund, currency),
+	}
+	return refundResp, nil
+}
+
 // GetResponseError 获取微信支付响应错误信息
 // 根据错误状态构造微信支付通知响应
 // 参数:
@@ -253,3 +682,321 @@ func (pp *WechatPaymentProvider) GetResponseError(err error) string {
 	// 转换为JSON字符串
 	return util.StructToJson(response)
 }
+
+// getWechatPaymentState 根据微信支付交易状态获取支付状态
+// 参数:
+//   - tradeState: 微信支付交易状态
+//
+// 返回:
+//   - PaymentState: 支付状态，未知交易状态返回 PaymentStateError
+func getWechatPaymentState(tradeState string) PaymentState {
+	switch tradeState {
+	case "SUCCESS": // 支付成功
+		return PaymentStatePaid
+	case "CLOSED": // 已关闭
+		return PaymentStateCanceled
+	case "NOTPAY", "USERPAYING": // 未支付：等待用户支付；用户支付中：用户正在支付
+		return PaymentStateCreated
+	default: // 未知状态
+		return PaymentStateError
+	}
+}
+
+// wechatErrorCodes 微信支付错误码与支付错误码的映射
+var wechatErrorCodes = map[string]ErrorCode{
+	"PARAM_ERROR":           ErrorCodeInvalidRequest,
+	"INVALID_REQUEST":       ErrorCodeInvalidRequest,
+	"APPID_MCHID_NOT_MATCH": ErrorCodeInvalidRequest,
+	"SIGN_ERROR":            ErrorCodeAuthFailed,
+	"NO_AUTH":               ErrorCodeAuthFailed,
+	"ORDER_NOT_EXIST":       ErrorCodeNotFound,
+	"RESOURCE_NOT_EXISTS":   ErrorCodeNotFound,
+	"ORDERPAID":             ErrorCodeDuplicateOrder,
+	"OUT_TRADE_NO_USED":     ErrorCodeDuplicateOrder,
+	"ORDER_CLOSED":          ErrorCodeOrderClosed,
+	"NOT_ENOUGH":            ErrorCodeInsufficientFunds,
+	"RULE_LIMIT":            ErrorCodeCardDeclined,
+	"FREQUENCY_LIMITED":     ErrorCodeRateLimited,
+	"RATELIMIT_EXCEEDED":    ErrorCodeRateLimited,
+	"SYSTEM_ERROR":          ErrorCodeProviderUnavailable,
+	"BANK_ERROR":            ErrorCodeProviderUnavailable,
+}
+
+// wrapWechatError 将微信支付错误响应映射为支付错误
+// 参数:
+//   - status: HTTP状态码
+//   - body: 错误响应体，格式为 {"code":"...","message":"..."}
+//
+// 返回:
+//   - error: 支付错误
+func wrapWechatError(status int, body string) error {
+	var errRsp struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal([]byte(body), &errRsp) != nil {
+		errRsp.Message = body
+	}
+
+	code, ok := wechatErrorCodes[errRsp.Code]
+	if !ok {
+		code = getErrorCodeByHTTPStatus(status)
+	}
+	return newError(ProviderTypeWechatPay, code, errRsp.Code, errRsp.Message)
+}
+
+/*
+ * 微信支付平台证书管理
+ */
+
+// wechatCertRefreshInterval 平台证书刷新间隔，微信支付要求小于12小时
+const wechatCertRefreshInterval = 6 * time.Hour
+
+// wechatCertMissRefreshInterval 遇到未知证书序列号时触发刷新的最小间隔，避免伪造的序列号频繁触发下载
+const wechatCertMissRefreshInterval = time.Minute
+
+// wechatPlatformCert 微信支付平台证书
+type wechatPlatformCert struct {
+	publicKey     *rsa.PublicKey // 证书公钥
+	content       string         // 证书内容（PEM）
+	effectiveTime time.Time      // 启用时间
+	expireTime    time.Time      // 过期时间
+}
+
+// wechatCertManager 微信支付平台证书管理器
+// 由后台协程定时下载平台证书并按序列号保存，证书轮换期间新旧证书同时有效，直到旧证书过期；
+// 定时刷新失败或遇到未知序列号时在处理通知时按需刷新。
+// 证书只保存在管理器中，不会修改共享的微信支付客户端，可以被并发调用
+type wechatCertManager struct {
+	mchId      string // 商户号
+	serialNo   string // 商户证书序列号
+	apiV3Key   string // API v3密钥，用于解密证书
+	privateKey string // 商户私钥，用于签名下载请求
+
+	refreshMu sync.Mutex // 串行化证书下载，并发通知只会触发一次下载
+
+	mu             sync.RWMutex                   // 保护以下字段
+	certs          map[string]*wechatPlatformCert // 序列号 -> 平台证书
+	newestSerialNo string                         // 启用时间最晚的有效证书序列号
+	lastRefresh    time.Time                      // 上次成功刷新时间
+	lastAttempt    time.Time                      // 上次尝试刷新时间
+
+	stopOnce sync.Once     // 保证只停止一次
+	stopCh   chan struct{} // 关闭时通知后台协程退出
+	doneCh   chan struct{} // 后台协程退出后关闭
+}
+
+// newWechatCertManager 创建平台证书管理器
+// 参数:
+//   - mchId: 商户号
+//   - serialNo: 商户证书序列号
+//   - apiV3Key: API v3密钥
+//   - privateKey: 商户私钥
+//
+// 返回:
+//   - *wechatCertManager: 平台证书管理器
+func newWechatCertManager(mchId string, serialNo string, apiV3Key string, privateKey string) *wechatCertManager {
+	return &wechatCertManager{
+		mchId:      mchId,
+		serialNo:   serialNo,
+		apiV3Key:   apiV3Key,
+		privateKey: privateKey,
+		certs:      map[string]*wechatPlatformCert{},
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+// start 启动后台协程，每隔 wechatCertRefreshInterval 刷新一次平台证书
+// 刷新失败时保留已有证书，处理通知时会按需重试
+func (m *wechatCertManager) start() {
+	go func() {
+		defer close(m.doneCh)
+		ticker := time.NewTicker(wechatCertRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				_ = m.refresh(ctx)
+				cancel()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop 停止后台协程并等待其退出，可以重复调用
+func (m *wechatCertManager) stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+	<-m.doneCh
+}
+
+// refresh 下载平台证书并合并到已有证书中
+// 已过期的证书会被移除，下载失败时保留已有证书
+// 参数:
+//   - ctx: 上下文
+//
+// 返回:
+//   - error: 错误信息
+func (m *wechatCertManager) refresh(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	return m.download(ctx)
+}
+
+// refreshIfStale 距上次成功刷新超过 maxAge 时刷新平台证书
+// 距上次尝试不足 wechatCertMissRefreshInterval 时不会重试，避免支付平台不可用或伪造的序列号频繁触发下载
+// 参数:
+//   - ctx: 上下文
+//   - maxAge: 证书最长使用时间
+//
+// 返回:
+//   - error: 错误信息
+func (m *wechatCertManager) refreshIfStale(ctx context.Context, maxAge time.Duration) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	m.mu.RLock()
+	stale := time.Since(m.lastRefresh) >= maxAge && time.Since(m.lastAttempt) >= wechatCertMissRefreshInterval
+	m.mu.RUnlock()
+	if !stale {
+		return nil
+	}
+	return m.download(ctx)
+}
+
+// download 下载平台证书，调用方需持有 refreshMu
+// 参数:
+//   - ctx: 上下文
+//
+// 返回:
+//   - error: 错误信息
+func (m *wechatCertManager) download(ctx context.Context) error {
+	m.mu.Lock()
+	m.lastAttempt = time.Now()
+	m.mu.Unlock()
+
+	certsRsp, err := wechat.GetPlatformCerts(ctx, m.mchId, m.apiV3Key, m.serialNo, m.privateKey)
+	if err != nil {
+		return wrapError(ProviderTypeWechatPay, err)
+	}
+	if certsRsp.Code != wechat.Success {
+		return wrapWechatError(certsRsp.Code, certsRsp.Error)
+	}
+
+	// 解析证书
+	certs := make(map[string]*wechatPlatformCert, len(certsRsp.Certs))
+	for _, item := range certsRsp.Certs {
+		publicKey, err := xpem.DecodePublicKey([]byte(item.PublicKey))
+		if err != nil {
+			return fmt.Errorf("invalid wechat platform cert %s: %w", item.SerialNo, err)
+		}
+		effectiveTime, err := time.Parse(time.RFC3339, item.EffectiveTime)
+		if err != nil {
+			return fmt.Errorf("invalid wechat platform cert %s effective time: %w", item.SerialNo, err)
+		}
+		expireTime, err := time.Parse(time.RFC3339, item.ExpireTime)
+		if err != nil {
+			return fmt.Errorf("invalid wechat platform cert %s expire time: %w", item.SerialNo, err)
+		}
+		certs[item.SerialNo] = &wechatPlatformCert{
+			publicKey:     publicKey,
+			content:       item.PublicKey,
+			effectiveTime: effectiveTime,
+			expireTime:    expireTime,
+		}
+	}
+	if len(certs) == 0 {
+		return errors.New("no wechat platform cert downloaded")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 合并证书，保留未过期的旧证书
+	now := time.Now()
+	for serialNo, cert := range m.certs {
+		if _, ok := certs[serialNo]; !ok && now.Before(cert.expireTime) {
+			certs[serialNo] = cert
+		}
+	}
+
+	// 记录启用时间最晚的有效证书，用于加密请求中的敏感信息
+	var newestSerialNo string
+	var newest *wechatPlatformCert
+	for serialNo, cert := range certs {
+		if now.Before(cert.expireTime) && (newest == nil || cert.effectiveTime.After(newest.effectiveTime)) {
+			newestSerialNo, newest = serialNo, cert
+		}
+	}
+	if newest == nil {
+		return errors.New("all wechat platform certs have expired")
+	}
+	m.certs = certs
+	m.newestSerialNo = newestSerialNo
+	m.lastRefresh = now
+	return nil
+}
+
+// getNewestCert 获取启用时间最晚的有效证书
+// 返回:
+//   - string: 证书序列号
+//   - string: 证书内容（PEM），没有有效证书时为空
+func (m *wechatCertManager) getNewestCert() (string, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cert, ok := m.certs[m.newestSerialNo]
+	if !ok {
+		return "", ""
+	}
+	return m.newestSerialNo, cert.content
+}
+
+// getPublicKey 根据序列号获取平台证书公钥
+// 距上次刷新超过 wechatCertRefreshInterval 时先刷新证书，刷新失败时继续使用未过期的已有证书；
+// 序列号未知时可能是新轮换的证书，会在限频范围内刷新一次证书后重试
+// 参数:
+//   - ctx: 上下文
+//   - serialNo: 平台证书序列号，即 Wechatpay-Serial 请求头
+//
+// 返回:
+//   - *rsa.PublicKey: 证书公钥
+//   - error: 错误信息
+func (m *wechatCertManager) getPublicKey(ctx context.Context, serialNo string) (*rsa.PublicKey, error) {
+	if m == nil {
+		return nil, errors.New("wechat platform certs are not loaded")
+	}
+	_ = m.refreshIfStale(ctx, wechatCertRefreshInterval)
+	if publicKey, ok := m.lookup(serialNo); ok {
+		return publicKey, nil
+	}
+
+	if err := m.refreshIfStale(ctx, wechatCertMissRefreshInterval); err != nil {
+		return nil, err
+	}
+	if publicKey, ok := m.lookup(serialNo); ok {
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("unknown wechat platform cert serial: %s", serialNo)
+}
+
+// lookup 查找未过期的平台证书公钥
+// 参数:
+//   - serialNo: 平台证书序列号
+//
+// 返回:
+//   - *rsa.PublicKey: 证书公钥
+//   - bool: 是否找到
+func (m *wechatCertManager) lookup(serialNo string) (*rsa.PublicKey, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cert, ok := m.certs[serialNo]
+	if !ok || time.Now().After(cert.expireTime) {
+		return nil, false
+	}
+	return cert.publicKey, true
+}
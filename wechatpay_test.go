@@ -0,0 +1,29 @@
+package payment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWechatPaymentProviderClose(t *testing.T) {
+	certManager := newWechatCertManager("mch_id", "serial_no", "api_v3_key", "private_key")
+	certManager.start()
+	pp := &WechatPaymentProvider{certManager: certManager}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = pp.Close()
+		_ = pp.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the cert refresh goroutine")
+	}
+	select {
+	case <-certManager.doneCh:
+	default:
+		t.Error("cert refresh goroutine is still running")
+	}
+}